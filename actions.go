@@ -0,0 +1,385 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/ewmh"
+	"github.com/fhs/gompd/mpd"
+)
+
+// parseActions parses a map of button numbers and action strings, as read
+// from the config file.
+func (bar *Bar) parseActions(m map[string]string) (map[xproto.Button]func() error,
+	error) {
+	am := make(map[xproto.Button]func() error)
+	for k, v := range m {
+		b, err := strconv.ParseUint(k, 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: Not a valid button", k)
+		}
+
+		am[xproto.Button(b)], err = bar.parseAction(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return am, nil
+}
+
+// parseAction parses an action string into a function. An action string
+// consist of a verb and its arguments, the following verbs are available:
+//
+//	popup <name>               Toggle the popup with the given name.
+//	desktop <n|prev|next>      Switch to the given desktop.
+//	window <id|prev|next>      Activate the given window, or cycle through
+//	                           the windows on the current desktop.
+//	close <id>                 Close the window with the given ID.
+//	mpd <toggle|prev|next>     Control MPD, requires a music block.
+//	notify <dismiss|clear>     Dismiss the newest notification, or clear
+//	                           all notifications.
+//	notify dismiss <id>        Dismiss the notification with the given ID.
+//	notify invoke <id> <key>   Invoke the action of the notification.
+//	volume <up|down|mute>      Change the volume of, or mute the default
+//	                           sink, requires a volume block.
+//	volume set <percent>       Set the volume of the default sink.
+//	backlight <up|down>        Change the brightness, requires a backlight
+//	                           block.
+//	backlight set <percent>    Set the brightness.
+//	keyboard <n|prev|next>     Switch to the given keyboard group, or cycle
+//	                           through the groups.
+//	exec <command>             Execute the command using `sh -c`.
+//
+// A `volume` action can be followed by `sink <index>` or `stream <index>` to
+// control a specific sink, or the stream of an application.
+func (bar *Bar) parseAction(s string) (func() error, error) {
+	f := strings.Fields(s)
+	if len(f) < 2 {
+		return nil, fmt.Errorf("parse %q: Not a valid action", s)
+	}
+
+	switch f[0] {
+	case "popup":
+		return func() error {
+			return bar.drawPopup(f[1])
+		}, nil
+	case "desktop":
+		switch f[1] {
+		case "prev":
+			return func() error {
+				return cycleDesktop(-1)
+			}, nil
+		case "next":
+			return func() error {
+				return cycleDesktop(1)
+			}, nil
+		default:
+			d, err := strconv.Atoi(f[1])
+			if err != nil {
+				return nil, fmt.Errorf("parse %q: Not a valid desktop", s)
+			}
+			return func() error {
+				return ewmh.CurrentDesktopReq(X, d)
+			}, nil
+		}
+	case "window":
+		switch f[1] {
+		case "prev":
+			return func() error {
+				return cycleWindow(-1)
+			}, nil
+		case "next":
+			return func() error {
+				return cycleWindow(1)
+			}, nil
+		default:
+			id, err := strconv.ParseUint(f[1], 0, 32)
+			if err != nil {
+				return nil, fmt.Errorf("parse %q: Not a valid window", s)
+			}
+			return func() error {
+				return ewmh.ActiveWindowReq(X, xproto.Window(id))
+			}, nil
+		}
+	case "close":
+		id, err := strconv.ParseUint(f[1], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: Not a valid window", s)
+		}
+		return func() error {
+			return ewmh.CloseWindow(X, xproto.Window(id))
+		}, nil
+	case "mpd":
+		switch f[1] {
+		case "toggle":
+			return func() error {
+				c, ok := bar.getStore("mpd").(*mpd.Client)
+				if !ok {
+					return fmt.Errorf("mpd: Not connected")
+				}
+				s, err := c.Status()
+				if err != nil {
+					return err
+				}
+
+				return c.Pause(s["state"] != "pause")
+			}, nil
+		case "prev":
+			return func() error {
+				c, ok := bar.getStore("mpd").(*mpd.Client)
+				if !ok {
+					return fmt.Errorf("mpd: Not connected")
+				}
+				return c.Previous()
+			}, nil
+		case "next":
+			return func() error {
+				c, ok := bar.getStore("mpd").(*mpd.Client)
+				if !ok {
+					return fmt.Errorf("mpd: Not connected")
+				}
+				return c.Next()
+			}, nil
+		}
+	case "notify":
+		return parseNotifyAction(s, f[1:])
+	case "volume":
+		return bar.parseVolumeAction(s, f[1:])
+	case "backlight":
+		return bar.parseBacklightAction(s, f[1:])
+	case "keyboard":
+		switch f[1] {
+		case "prev":
+			return func() error {
+				return cycleXKBGroup(-1)
+			}, nil
+		case "next":
+			return func() error {
+				return cycleXKBGroup(1)
+			}, nil
+		default:
+			g, err := strconv.Atoi(f[1])
+			if err != nil || g < 0 || g > 3 {
+				return nil, fmt.Errorf("parse %q: Not a valid group", s)
+			}
+			return func() error {
+				return lockXKBGroup(g)
+			}, nil
+		}
+	case "exec":
+		cmd := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s),
+			"exec"))
+		return func() error {
+			c := exec.Command("sh", "-c", cmd)
+			c.Stdout = os.Stdout
+			return c.Start()
+		}, nil
+	}
+
+	return nil, fmt.Errorf("parse %q: Not a valid action", s)
+}
+
+// cycleDesktop switches to the desktop `d` positions away from the current
+// desktop, wrapping around at both ends.
+func cycleDesktop(d int) error {
+	cur, err := ewmh.CurrentDesktopGet(X)
+	if err != nil {
+		return err
+	}
+	n, err := ewmh.NumberOfDesktopsGet(X)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+
+	return ewmh.CurrentDesktopReq(X, cycle(int(cur), d, int(n)))
+}
+
+// cycle returns the index `d` positions away from index `i` in a list of `n`
+// items, wrapping around at both ends.
+func cycle(i, d, n int) int {
+	return ((i+d)%n + n) % n
+}
+
+// cycleWindow activates the window `d` positions away from the active window,
+// in the list of windows on the current desktop, wrapping around at both
+// ends.
+func cycleWindow(d int) error {
+	wl, err := desktopWindows()
+	if err != nil {
+		return err
+	}
+	if len(wl) == 0 {
+		return nil
+	}
+	act, err := ewmh.ActiveWindowGet(X)
+	if err != nil {
+		return err
+	}
+
+	// Find the active window, if it isn't on the current desktop start at
+	// the first window.
+	cur := 0
+	for i, id := range wl {
+		if id == act {
+			cur = (i + d) % len(wl)
+			break
+		}
+	}
+	if cur < 0 {
+		cur += len(wl)
+	}
+
+	return ewmh.ActiveWindowReq(X, wl[cur])
+}
+
+// parseNotifyAction parses the arguments of a `notify` action.
+func parseNotifyAction(s string, args []string) (func() error, error) {
+	var id uint64
+	if len(args) > 1 {
+		var err error
+		if id, err = strconv.ParseUint(args[1], 10, 32); err != nil {
+			return nil, fmt.Errorf("parse %q: Not a valid notification", s)
+		}
+	}
+
+	var f func(n *notifier)
+	switch {
+	case args[0] == "dismiss":
+		f = func(n *notifier) {
+			n.dismiss(uint32(id))
+		}
+	case args[0] == "clear":
+		f = func(n *notifier) {
+			n.clear()
+		}
+	case args[0] == "invoke" && len(args) > 2:
+		f = func(n *notifier) {
+			n.invoke(uint32(id), strings.Join(args[2:], " "))
+		}
+	default:
+		return nil, fmt.Errorf("parse %q: Not a valid action", s)
+	}
+
+	return func() error {
+		n, err := notifications()
+		if err != nil {
+			return err
+		}
+		f(n)
+		return nil
+	}, nil
+}
+
+// parseVolumeAction parses the arguments of a `volume` action.
+func (bar *Bar) parseVolumeAction(s string, args []string) (func() error,
+	error) {
+	// Parse the percentage of the `set` verb.
+	var v float64
+	if args[0] == "set" {
+		if len(args) < 2 {
+			return nil, fmt.Errorf("parse %q: Not a valid action", s)
+		}
+		p, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: Not a valid volume", s)
+		}
+		v = p / 100
+		args = append(args[:1], args[2:]...)
+	}
+
+	// Parse the channel, if any.
+	var kind rune
+	var index uint64
+	switch {
+	case len(args) == 1:
+	case len(args) == 3 && (args[1] == "sink" || args[1] == "stream"):
+		kind = 's'
+		if args[1] == "stream" {
+			kind = 'i'
+		}
+		var err error
+		if index, err = strconv.ParseUint(args[2], 10, 32); err != nil {
+			return nil, fmt.Errorf("parse %q: Not a valid channel", s)
+		}
+	default:
+		return nil, fmt.Errorf("parse %q: Not a valid action", s)
+	}
+
+	var f func(vc *volumeControl) error
+	switch args[0] {
+	case "up":
+		f = func(vc *volumeControl) error {
+			return vc.adjust(kind, uint32(index), 1)
+		}
+	case "down":
+		f = func(vc *volumeControl) error {
+			return vc.adjust(kind, uint32(index), -1)
+		}
+	case "mute":
+		f = func(vc *volumeControl) error {
+			return vc.toggle(kind, uint32(index))
+		}
+	case "set":
+		f = func(vc *volumeControl) error {
+			return vc.set(kind, uint32(index), v)
+		}
+	default:
+		return nil, fmt.Errorf("parse %q: Not a valid action", s)
+	}
+
+	return func() error {
+		vc, ok := bar.getStore("volume").(*volumeControl)
+		if !ok {
+			return fmt.Errorf("volume: Requires a volume block")
+		}
+		return f(vc)
+	}, nil
+}
+
+// parseBacklightAction parses the arguments of a `backlight` action.
+func (bar *Bar) parseBacklightAction(s string, args []string) (func() error,
+	error) {
+	var f func(bc *backlightControl) error
+	switch {
+	case len(args) == 1 && args[0] == "up":
+		f = func(bc *backlightControl) error {
+			return bc.change(func(v float64) float64 {
+				return v + bc.step
+			})
+		}
+	case len(args) == 1 && args[0] == "down":
+		f = func(bc *backlightControl) error {
+			return bc.change(func(v float64) float64 {
+				return v - bc.step
+			})
+		}
+	case len(args) == 2 && args[0] == "set":
+		p, err := strconv.ParseFloat(args[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: Not a valid brightness", s)
+		}
+		f = func(bc *backlightControl) error {
+			return bc.change(func(float64) float64 {
+				return p / 100
+			})
+		}
+	default:
+		return nil, fmt.Errorf("parse %q: Not a valid action", s)
+	}
+
+	return func() error {
+		bc, ok := bar.getStore("backlight").(*backlightControl)
+		if !ok {
+			return fmt.Errorf("backlight: Requires a backlight block")
+		}
+		return f(bc)
+	}, nil
+}
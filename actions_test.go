@@ -0,0 +1,27 @@
+package main
+
+import "testing"
+
+func TestCycle(t *testing.T) {
+	for _, tc := range []struct {
+		i, d, n, want int
+	}{
+		{0, 1, 1, 0},
+		{0, -1, 1, 0},
+		{0, 5, 1, 0},
+		{0, 1, 4, 1},
+		{2, -1, 4, 1},
+		{0, -1, 4, 3},
+		{3, 1, 4, 0},
+		{3, -1, 4, 2},
+		{1, 9, 4, 2},
+		{1, -9, 4, 0},
+		{3, 4, 4, 3},
+		{0, -12, 4, 0},
+	} {
+		if i := cycle(tc.i, tc.d, tc.n); i != tc.want {
+			t.Errorf("cycle(%d, %d, %d) = %d, want %d", tc.i, tc.d, tc.n, i,
+				tc.want)
+		}
+	}
+}
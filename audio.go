@@ -0,0 +1,506 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jfreymuth/pulse/proto"
+)
+
+// mixerChannel is a struct with information about a sink, or about the stream
+// of an application playing on a sink.
+type mixerChannel struct {
+	// The kind of channel, `s` for sinks and `i` for streams, and its index.
+	kind  rune
+	index uint32
+
+	// The name of the channel.
+	name string
+
+	// The volume, 1 is 100%, and if the channel is muted.
+	volume float64
+	mute   bool
+
+	// If the channel is the default sink.
+	def bool
+}
+
+// mixer is an interface to a sound server or sound card that controls the
+// volume of its channels.
+type mixer interface {
+	// list returns the channels, the default sink is first and is followed
+	// by the other sinks and the streams. There may be no default sink, for
+	// example when the server has no sinks.
+	list() ([]mixerChannel, error)
+
+	// setVolume sets the volume of the channel, 1 is 100%.
+	setVolume(c mixerChannel, v float64) error
+
+	// setMute mutes or unmutes the channel.
+	setMute(c mixerChannel, mute bool) error
+
+	// changes returns a channel that receives a value every time the volume
+	// or mute state of a channel changes, or channels are added or removed.
+	changes() <-chan struct{}
+
+	// close closes the connection.
+	close() error
+}
+
+// connectMixer connects to the PulseAudio or PipeWire server using `server`,
+// or to the ALSA control `control` using `amixer` if `backend` is `alsa` or
+// if `backend` is `auto` and there is no sound server.
+func connectMixer(ctx context.Context, backend, server, control string) (mixer,
+	error) {
+	switch backend {
+	case "pulse":
+		return newPulseMixer(server)
+	case "alsa":
+		return newALSAMixer(ctx, control)
+	case "auto":
+		if m, err := newPulseMixer(server); err == nil {
+			return m, nil
+		}
+		return newALSAMixer(ctx, control)
+	}
+	return nil, fmt.Errorf("parse %q: Not a valid backend", backend)
+}
+
+// pulseMixer is a mixer that talks to a PulseAudio server, or to the
+// PulseAudio server of PipeWire, using the native protocol.
+type pulseMixer struct {
+	c    *proto.Client
+	conn net.Conn
+
+	// The channel that receives a value on every subscription event.
+	events chan struct{}
+}
+
+// newPulseMixer connects to the server and subscribes to sink and stream
+// events.
+func newPulseMixer(server string) (*pulseMixer, error) {
+	c, conn, err := proto.Connect(server)
+	if err != nil {
+		return nil, err
+	}
+	m := &pulseMixer{c: c, conn: conn, events: make(chan struct{}, 1)}
+
+	// Set the client name, and forward subscription events.
+	if err := c.Request(&proto.SetClientName{Props: proto.PropList{
+		"application.name": proto.PropListString("melonbar"),
+	}}, &proto.SetClientNameReply{}); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c.Callback = func(msg interface{}) {
+		switch msg.(type) {
+		case *proto.SubscribeEvent, *proto.ConnectionClosed:
+			select {
+			case m.events <- struct{}{}:
+			default:
+			}
+		}
+	}
+	if err := c.Request(&proto.Subscribe{Mask: proto.SubscriptionMaskSink |
+		proto.SubscriptionMaskSinkInput | proto.SubscriptionMaskServer},
+		nil); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func (m *pulseMixer) list() ([]mixerChannel, error) {
+	var si proto.GetServerInfoReply
+	if err := m.c.Request(&proto.GetServerInfo{}, &si); err != nil {
+		return nil, err
+	}
+	var sl proto.GetSinkInfoListReply
+	if err := m.c.Request(&proto.GetSinkInfoList{}, &sl); err != nil {
+		return nil, err
+	}
+	var il proto.GetSinkInputInfoListReply
+	if err := m.c.Request(&proto.GetSinkInputInfoList{}, &il); err != nil {
+		return nil, err
+	}
+
+	// Add the sinks, the default sink first.
+	var cl []mixerChannel
+	for _, s := range sl {
+		c := mixerChannel{
+			kind:   's',
+			index:  s.SinkIndex,
+			name:   s.Device,
+			volume: pulseVolume(s.ChannelVolumes),
+			mute:   s.Mute,
+			def:    s.SinkName == si.DefaultSinkName,
+		}
+		if c.name == "" {
+			c.name = s.SinkName
+		}
+		if c.def {
+			cl = append([]mixerChannel{c}, cl...)
+		} else {
+			cl = append(cl, c)
+		}
+	}
+
+	// Add the streams, named after their application.
+	for _, i := range il {
+		c := mixerChannel{
+			kind:   'i',
+			index:  i.SinkInputIndex,
+			name:   i.MediaName,
+			volume: pulseVolume(i.ChannelVolumes),
+			mute:   i.Muted,
+		}
+		if p, ok := i.Properties["application.name"]; ok {
+			c.name = strings.TrimRight(string(p), "\x00")
+		}
+		cl = append(cl, c)
+	}
+
+	return cl, nil
+}
+
+func (m *pulseMixer) setVolume(c mixerChannel, v float64) error {
+	// Keep the balance between the channels, by scaling all channels.
+	cl, err := m.volumes(c)
+	if err != nil {
+		return err
+	}
+	cur := pulseVolume(cl)
+	for i := range cl {
+		if cur > 0 {
+			cl[i] = uint32(math.Round(float64(cl[i]) * v / cur))
+		} else {
+			cl[i] = uint32(math.Round(v * float64(proto.VolumeNorm)))
+		}
+	}
+
+	if c.kind == 'i' {
+		return m.c.Request(&proto.SetSinkInputVolume{SinkInputIndex: c.index,
+			ChannelVolumes: cl}, nil)
+	}
+	return m.c.Request(&proto.SetSinkVolume{SinkIndex: c.index,
+		ChannelVolumes: cl}, nil)
+}
+
+func (m *pulseMixer) setMute(c mixerChannel, mute bool) error {
+	if c.kind == 'i' {
+		return m.c.Request(&proto.SetSinkInputMute{SinkInputIndex: c.index,
+			Mute: mute}, nil)
+	}
+	return m.c.Request(&proto.SetSinkMute{SinkIndex: c.index, Mute: mute},
+		nil)
+}
+
+func (m *pulseMixer) changes() <-chan struct{} {
+	return m.events
+}
+
+func (m *pulseMixer) close() error {
+	return m.conn.Close()
+}
+
+// volumes returns the volume of every channel of the sink or stream.
+func (m *pulseMixer) volumes(c mixerChannel) (proto.ChannelVolumes, error) {
+	if c.kind == 'i' {
+		var r proto.GetSinkInputInfoReply
+		if err := m.c.Request(&proto.GetSinkInputInfo{SinkInputIndex: c.
+			index}, &r); err != nil {
+			return nil, err
+		}
+		return r.ChannelVolumes, nil
+	}
+
+	var r proto.GetSinkInfoReply
+	if err := m.c.Request(&proto.GetSinkInfo{SinkIndex: c.index},
+		&r); err != nil {
+		return nil, err
+	}
+	return r.ChannelVolumes, nil
+}
+
+// pulseVolume returns the loudest channel volume, 1 is 100%.
+func pulseVolume(cl proto.ChannelVolumes) float64 {
+	var v uint32
+	for _, c := range cl {
+		if c > v {
+			v = c
+		}
+	}
+	return float64(v) / float64(proto.VolumeNorm)
+}
+
+// alsaMixer is a mixer that controls a simple ALSA control using the `amixer`
+// command. It only has one channel.
+type alsaMixer struct {
+	control string
+	c       chan struct{}
+	cancel  context.CancelFunc
+	once    sync.Once
+}
+
+// newALSAMixer checks that the control exists, and watches for ALSA events
+// using `amixer events`.
+func newALSAMixer(ctx context.Context, control string) (*alsaMixer, error) {
+	m := &alsaMixer{control: control, c: make(chan struct{}, 1)}
+	if _, err := m.list(); err != nil {
+		return nil, err
+	}
+
+	ctx, m.cancel = context.WithCancel(ctx)
+	cmd := exec.CommandContext(ctx, "amixer", "events")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		m.cancel()
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		m.cancel()
+		return nil, err
+	}
+	go func() {
+		s := bufio.NewScanner(out)
+		for s.Scan() {
+			select {
+			case m.c <- struct{}{}:
+			default:
+			}
+		}
+		cmd.Wait()
+	}()
+
+	return m, nil
+}
+
+// alsaRe matches the volume in percent and the mute state of a channel in the
+// output of `amixer get`.
+var alsaRe = regexp.MustCompile(`\[(\d+)%\](?:.*\[(on|off)\])?`)
+
+func (m *alsaMixer) list() ([]mixerChannel, error) {
+	b, err := exec.Command("amixer", "get", m.control).Output()
+	if err != nil {
+		return nil, fmt.Errorf("amixer %q: %v", m.control, err)
+	}
+	return parseAmixer(m.control, string(b))
+}
+
+// parseAmixer parses the output of `amixer get` for the control into a
+// single channel.
+func parseAmixer(control, out string) ([]mixerChannel, error) {
+	// Use the loudest channel, the control is muted if all channels are.
+	c := mixerChannel{kind: 's', name: control, mute: true, def: true}
+	found := false
+	for _, sm := range alsaRe.FindAllStringSubmatch(out, -1) {
+		p, _ := strconv.Atoi(sm[1])
+		c.volume = math.Max(c.volume, float64(p)/100)
+		if sm[2] != "off" {
+			c.mute = false
+		}
+		found = true
+	}
+	if !found {
+		return nil, fmt.Errorf("amixer %q: No volume found", control)
+	}
+
+	return []mixerChannel{c}, nil
+}
+
+func (m *alsaMixer) setVolume(c mixerChannel, v float64) error {
+	return exec.Command("amixer", "-q", "set", m.control, strconv.Itoa(int(
+		math.Round(v*100)))+"%").Run()
+}
+
+func (m *alsaMixer) setMute(c mixerChannel, mute bool) error {
+	s := "unmute"
+	if mute {
+		s = "mute"
+	}
+	return exec.Command("amixer", "-q", "set", m.control, s).Run()
+}
+
+func (m *alsaMixer) changes() <-chan struct{} {
+	return m.c
+}
+
+func (m *alsaMixer) close() error {
+	m.once.Do(m.cancel)
+	return nil
+}
+
+// volumeControl is a struct that keeps the mixer of a volume block, and the
+// channels read the last time they changed.
+type volumeControl struct {
+	sync.Mutex
+
+	// The mixer, this is nil until the block connected to it.
+	m        mixer
+	channels []mixerChannel
+
+	// The volume step and the maximum volume, 1 is 100%.
+	step, max float64
+}
+
+// update reads the channels from the mixer.
+func (vc *volumeControl) update() ([]mixerChannel, error) {
+	vc.Lock()
+	m := vc.m
+	vc.Unlock()
+	if m == nil {
+		return nil, fmt.Errorf("volume: Not connected")
+	}
+
+	cl, err := m.list()
+	if err != nil {
+		return nil, err
+	}
+
+	vc.Lock()
+	vc.channels = cl
+	vc.Unlock()
+
+	return cl, nil
+}
+
+// defaultSink returns the default sink, or false if there is none.
+func defaultSink(cl []mixerChannel) (mixerChannel, bool) {
+	for _, c := range cl {
+		if c.def {
+			return c, true
+		}
+	}
+	return mixerChannel{}, false
+}
+
+// get returns the channels read the last time they changed.
+func (vc *volumeControl) get() []mixerChannel {
+	vc.Lock()
+	defer vc.Unlock()
+
+	return append([]mixerChannel(nil), vc.channels...)
+}
+
+// change calls `f` with the mixer and the channel with the given kind and
+// index, or the default sink if `kind` is zero.
+func (vc *volumeControl) change(kind rune, index uint32, f func(m mixer,
+	c mixerChannel) error) error {
+	vc.Lock()
+	m := vc.m
+	var c *mixerChannel
+	for i := range vc.channels {
+		if kind == 0 && vc.channels[i].def || kind != 0 && vc.channels[i].
+			kind == kind && vc.channels[i].index == index {
+			c = &vc.channels[i]
+			break
+		}
+	}
+	vc.Unlock()
+
+	if m == nil {
+		return fmt.Errorf("volume: Not connected")
+	}
+	if c == nil {
+		return fmt.Errorf("volume: No such channel")
+	}
+	return f(m, *c)
+}
+
+// set sets the volume of the channel, limited to the maximum volume.
+func (vc *volumeControl) set(kind rune, index uint32, v float64) error {
+	return vc.change(kind, index, func(m mixer, c mixerChannel) error {
+		return m.setVolume(c, math.Max(0, math.Min(vc.max, v)))
+	})
+}
+
+// adjust changes the volume of the channel by `d` steps, limited to the
+// maximum volume.
+func (vc *volumeControl) adjust(kind rune, index uint32, d float64) error {
+	return vc.change(kind, index, func(m mixer, c mixerChannel) error {
+		return m.setVolume(c, math.Max(0, math.Min(vc.max, c.volume+d*vc.
+			step)))
+	})
+}
+
+// toggle mutes or unmutes the channel.
+func (vc *volumeControl) toggle(kind rune, index uint32) error {
+	return vc.change(kind, index, func(m mixer, c mixerChannel) error {
+		return m.setMute(c, !c.mute)
+	})
+}
+
+// run connects to a mixer using `connect`, and calls `f` with the channels
+// every time they change until `ctx` is done. If connecting fails or the
+// connection is lost, `f` is called with the error and the control connects
+// again after `delay`, which doubles after every failed attempt up to a
+// minute.
+func (vc *volumeControl) run(ctx context.Context, connect func() (mixer,
+	error), delay time.Duration, f func([]mixerChannel, error)) {
+	min := delay
+
+	// Function that connects to the mixer, and reads the channels until the
+	// connection is lost or `ctx` is done.
+	run := func() error {
+		m, err := connect()
+		if err != nil {
+			return err
+		}
+		defer m.close()
+		vc.Lock()
+		vc.m = m
+		vc.Unlock()
+		defer func() {
+			vc.Lock()
+			vc.m = nil
+			vc.channels = nil
+			vc.Unlock()
+		}()
+		delay = min
+
+		for {
+			cl, err := vc.update()
+			if err != nil {
+				return err
+			}
+			f(cl, nil)
+
+			// Wait for the mixer to change.
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-m.changes():
+			}
+		}
+	}
+
+	for {
+		err := run()
+		if ctx.Err() != nil {
+			return
+		}
+		f(nil, err)
+
+		// Wait before connecting again.
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if delay < time.Minute {
+			delay *= 2
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseAmixer(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		want mixerChannel
+	}{
+		{"stereo", mixerChannel{kind: 's', name: "Master", volume: 0.7,
+			def: true}},
+		{"muted", mixerChannel{kind: 's', name: "Master", volume: 0.6,
+			mute: true, def: true}},
+		{"mono", mixerChannel{kind: 's', name: "Master", volume: 1,
+			def: true}},
+	} {
+		b, err := os.ReadFile("testdata/amixer/" + tc.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cl, err := parseAmixer("Master", string(b))
+		if err != nil {
+			t.Errorf("parseAmixer(%s): %v", tc.file, err)
+			continue
+		}
+		if !reflect.DeepEqual(cl, []mixerChannel{tc.want}) {
+			t.Errorf("parseAmixer(%s) = %+v, want %+v", tc.file, cl, tc.want)
+		}
+	}
+
+	// A control without playback volume can't be used.
+	b, err := os.ReadFile("testdata/amixer/capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseAmixer("Capture", string(b)); err == nil {
+		t.Error("parseAmixer(capture): Expected an error")
+	}
+}
+
+func TestAlsaRe(t *testing.T) {
+	for s, want := range map[string][]string{
+		"Mono: Playback 255 [100%] [0.00dB]":               {"100", ""},
+		"Front Left: Playback 52 [60%] [-26.25dB] [on]":    {"60", "on"},
+		"Front Right: Playback 0 [0%] [-99999.99dB] [off]": {"0", "off"},
+	} {
+		sm := alsaRe.FindStringSubmatch(s)
+		if len(sm) != 3 || !reflect.DeepEqual(sm[1:], want) {
+			t.Errorf("alsaRe(%q) = %q, want %q", s, sm, want)
+		}
+	}
+	if alsaRe.MatchString("Limits: Playback 0 - 87") {
+		t.Error("alsaRe: Matched the limits")
+	}
+}
+
+// fakeMixer is a mixer that keeps its channels in memory.
+type fakeMixer struct {
+	channels []mixerChannel
+
+	// The channel returned by `changes`, the error returned by `list` and
+	// if the mixer is closed.
+	c      chan struct{}
+	err    error
+	closed bool
+}
+
+func (m *fakeMixer) list() ([]mixerChannel, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return append([]mixerChannel(nil), m.channels...), nil
+}
+
+func (m *fakeMixer) setVolume(c mixerChannel, v float64) error {
+	return m.change(c, func(fc *mixerChannel) {
+		fc.volume = v
+	})
+}
+
+func (m *fakeMixer) setMute(c mixerChannel, mute bool) error {
+	return m.change(c, func(fc *mixerChannel) {
+		fc.mute = mute
+	})
+}
+
+func (m *fakeMixer) change(c mixerChannel, f func(*mixerChannel)) error {
+	for i := range m.channels {
+		if m.channels[i].kind == c.kind && m.channels[i].index == c.index {
+			f(&m.channels[i])
+			return nil
+		}
+	}
+	return fmt.Errorf("fake: No such channel")
+}
+
+func (m *fakeMixer) changes() <-chan struct{} {
+	return m.c
+}
+
+func (m *fakeMixer) close() error {
+	m.closed = true
+	return nil
+}
+
+func TestVolumeControl(t *testing.T) {
+	vc := &volumeControl{step: 0.05, max: 1.2}
+	if err := vc.adjust(0, 0, 1); err == nil {
+		t.Error("adjust: Expected an error without a mixer")
+	}
+
+	m := &fakeMixer{channels: []mixerChannel{
+		{kind: 'i', index: 1, volume: 1},
+		{kind: 's', index: 2, volume: 0.8},
+		{kind: 's', index: 1, volume: 0.5, def: true},
+	}}
+	vc.m = m
+
+	for _, tc := range []struct {
+		name    string
+		f       func() error
+		kind    rune
+		index   uint32
+		want    float64
+		wantErr bool
+	}{
+		{name: "adjust default sink", f: func() error {
+			return vc.adjust(0, 0, 1)
+		}, kind: 's', index: 1, want: 0.55},
+		{name: "adjust stream past max", f: func() error {
+			return vc.adjust('i', 1, 10)
+		}, kind: 'i', index: 1, want: 1.2},
+		{name: "adjust sink below zero", f: func() error {
+			return vc.adjust('s', 2, -100)
+		}, kind: 's', index: 2, want: 0},
+		{name: "set past max", f: func() error {
+			return vc.set('s', 2, 2)
+		}, kind: 's', index: 2, want: 1.2},
+		{name: "set below zero", f: func() error {
+			return vc.set(0, 0, -1)
+		}, kind: 's', index: 1, want: 0},
+		{name: "set unknown channel", f: func() error {
+			return vc.set('i', 9, 0.5)
+		}, wantErr: true},
+	} {
+		if _, err := vc.update(); err != nil {
+			t.Fatal(err)
+		}
+		err := tc.f()
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("%s: Expected an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		for _, c := range m.channels {
+			if c.kind == tc.kind && c.index == tc.index && math.Abs(c.
+				volume-tc.want) > 1e-9 {
+				t.Errorf("%s: Volume is %v, want %v", tc.name, c.volume,
+					tc.want)
+			}
+		}
+	}
+
+	// Toggling mutes and unmutes the channel.
+	for _, want := range []bool{true, false} {
+		if _, err := vc.update(); err != nil {
+			t.Fatal(err)
+		}
+		if err := vc.toggle('i', 1); err != nil {
+			t.Fatal(err)
+		}
+		if m.channels[0].mute != want {
+			t.Errorf("toggle: Muted is %v, want %v", m.channels[0].mute, want)
+		}
+	}
+
+	// Without a default sink the default sink can't be changed.
+	m.channels[2].def = false
+	if _, err := vc.update(); err != nil {
+		t.Fatal(err)
+	}
+	if err := vc.toggle(0, 0); err == nil {
+		t.Error("toggle: Expected an error without a default sink")
+	}
+}
+
+func TestVolumeRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The first attempt to connect fails, the second mixer loses its
+	// connection and the third mixer stays connected.
+	ml := []*fakeMixer{nil, {c: make(chan struct{})},
+		{c: make(chan struct{})}}
+	ml[1].channels = []mixerChannel{{kind: 's', index: 1, volume: 0.5,
+		def: true}}
+	var n int
+	type result struct {
+		cl  []mixerChannel
+		err error
+	}
+	rc := make(chan result)
+
+	vc := &volumeControl{step: 0.05, max: 1}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		vc.run(ctx, func() (mixer, error) {
+			n++
+			if n > len(ml) || ml[n-1] == nil {
+				return nil, fmt.Errorf("fake: Connection refused")
+			}
+			return ml[n-1], nil
+		}, time.Millisecond, func(cl []mixerChannel, err error) {
+			rc <- result{cl, err}
+		})
+	}()
+
+	// wait returns the next result, and checks if it has an error.
+	wait := func(name string, wantErr bool) []mixerChannel {
+		select {
+		case r := <-rc:
+			if (r.err != nil) != wantErr {
+				t.Fatalf("%s: Got error %v, want an error %t", name, r.err,
+					wantErr)
+			}
+			return r.cl
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Timed out", name)
+		}
+		return nil
+	}
+
+	wait("refused", true)
+	if cl := wait("connected", false); len(cl) != 1 || cl[0].volume != 0.5 {
+		t.Errorf("connected: Got channels %+v", cl)
+	}
+
+	// Changes are read from the mixer.
+	if err := vc.adjust(0, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	ml[1].c <- struct{}{}
+	if cl := wait("changed", false); len(cl) != 1 || math.Abs(cl[0].volume-
+		0.6) > 1e-9 {
+		t.Errorf("changed: Got channels %+v", cl)
+	}
+
+	// A lost connection closes the mixer and connects again.
+	ml[1].err = fmt.Errorf("fake: Connection lost")
+	ml[1].c <- struct{}{}
+	wait("lost", true)
+	if !ml[1].closed {
+		t.Error("lost: The mixer isn't closed")
+	}
+	if err := vc.adjust(0, 0, 1); err == nil {
+		t.Error("lost: Expected an error while disconnected")
+	}
+	if cl := wait("reconnected", false); len(cl) != 0 {
+		t.Errorf("reconnected: Got channels %+v, want none", cl)
+	}
+
+	// The control stops once the context is done.
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("run: Didn't stop")
+	}
+	if !ml[2].closed {
+		t.Error("run: The mixer isn't closed")
+	}
+	if n != len(ml) {
+		t.Errorf("run: Connected %d times, want %d", n, len(ml))
+	}
+}
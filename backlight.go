@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/randr"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/xprop"
+	"github.com/fsnotify/fsnotify"
+)
+
+// backlight is an interface to a backlight device.
+type backlight interface {
+	// get returns the brightness, 1 is 100%.
+	get() (float64, error)
+
+	// set sets the brightness, 1 is 100%.
+	set(v float64) error
+
+	// changes returns a channel that receives a value every time the
+	// brightness changes, until `ctx` is done.
+	changes(ctx context.Context) <-chan struct{}
+}
+
+// openBacklight opens the backlight `device` in sysfs below `root`, or the
+// backlight of the RandR output `output`, depending on `source`. The source
+// can be `sysfs`, `randr` or `auto`, which prefers sysfs. An empty device or
+// output name picks the first one that has a backlight. If sysfs isn't
+// writable the brightness is set using the `helper` command.
+func openBacklight(source, root, device, output, helper string) (backlight,
+	error) {
+	switch source {
+	case "sysfs":
+		return newSysfsBacklight(root, device, helper)
+	case "randr":
+		return newRandRBacklight(output)
+	case "auto":
+		if b, err := newSysfsBacklight(root, device, helper); err == nil {
+			return b, nil
+		}
+		return newRandRBacklight(output)
+	}
+	return nil, fmt.Errorf("parse %q: Not a valid source", source)
+}
+
+// sysfsBacklight is a backlight device in the `backlight` class in sysfs.
+type sysfsBacklight struct {
+	// The directory of the device, and its maximum brightness.
+	dir string
+	max int
+
+	// The command that sets the brightness if sysfs isn't writable, `%v` is
+	// replaced by the raw brightness and `%p` by the percentage.
+	helper string
+}
+
+func newSysfsBacklight(root, device, helper string) (*sysfsBacklight,
+	error) {
+	d := filepath.Join(root, "class", "backlight", device)
+	if device == "" {
+		dl, err := filepath.Glob(filepath.Join(root, "class", "backlight",
+			"*"))
+		if err != nil {
+			return nil, err
+		}
+		if len(dl) == 0 {
+			return nil, fmt.Errorf("backlight %q: No devices found", root)
+		}
+		d = dl[0]
+	}
+
+	max, err := strconv.Atoi(readSysfs(d, "max_brightness"))
+	if err != nil || max <= 0 {
+		return nil, fmt.Errorf("backlight %q: Not a valid device", d)
+	}
+
+	return &sysfsBacklight{dir: d, max: max, helper: helper}, nil
+}
+
+func (b *sysfsBacklight) get() (float64, error) {
+	v, err := strconv.Atoi(readSysfs(b.dir, "brightness"))
+	if err != nil {
+		return 0, fmt.Errorf("backlight %q: Not a valid brightness", b.dir)
+	}
+	return float64(v) / float64(b.max), nil
+}
+
+func (b *sysfsBacklight) set(v float64) error {
+	raw := int(math.Round(v * float64(b.max)))
+	err := os.WriteFile(filepath.Join(b.dir, "brightness"), []byte(strconv.
+		Itoa(raw)), 0644)
+	if err == nil || b.helper == "" {
+		return err
+	}
+
+	// Fall back to the helper command, which usually runs with more
+	// privileges.
+	cmd := strings.NewReplacer("%v", strconv.Itoa(raw), "%p", strconv.Itoa(
+		int(math.Round(v*100)))).Replace(b.helper)
+	if out, err := exec.Command("sh", "-c", cmd).CombinedOutput(); err != nil {
+		return fmt.Errorf("backlight %q: %v: %s", cmd, err, strings.
+			TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (b *sysfsBacklight) changes(ctx context.Context) <-chan struct{} {
+	c := make(chan struct{}, 1)
+
+	// Writing to a sysfs attribute generates an inotify event.
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println(err)
+		return c
+	}
+	for _, n := range []string{"brightness", "actual_brightness"} {
+		if err := w.Add(filepath.Join(b.dir, n)); err != nil && !os.
+			IsNotExist(err) {
+			log.Println(err)
+		}
+	}
+
+	go func() {
+		defer w.Close()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.Events:
+			case err := <-w.Errors:
+				log.Println(err)
+				continue
+			}
+
+			select {
+			case c <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return c
+}
+
+// randrBacklight is the backlight property of a RandR output, which is set by
+// some X drivers.
+type randrBacklight struct {
+	output randr.Output
+	atom   xproto.Atom
+
+	// The range of the property.
+	min, max int32
+}
+
+func newRandRBacklight(name string) (*randrBacklight, error) {
+	res, err := randr.GetScreenResourcesCurrent(X.Conn(), X.RootWin()).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	// Drivers use either name for the property.
+	for _, an := range []string{"Backlight", "BACKLIGHT"} {
+		atom, err := xprop.Atm(X, an)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, o := range res.Outputs {
+			oi, err := randr.GetOutputInfo(X.Conn(), o, res.ConfigTimestamp).
+				Reply()
+			if err != nil {
+				return nil, err
+			}
+			if name != "" && string(oi.Name) != name {
+				continue
+			}
+
+			q, err := randr.QueryOutputProperty(X.Conn(), o, atom).Reply()
+			if err != nil || !q.Range || len(q.ValidValues) != 2 {
+				continue
+			}
+			return &randrBacklight{output: o, atom: atom, min: q.
+				ValidValues[0], max: q.ValidValues[1]}, nil
+		}
+	}
+
+	return nil, fmt.Errorf("backlight %q: No RandR output with a backlight",
+		name)
+}
+
+func (b *randrBacklight) get() (float64, error) {
+	r, err := randr.GetOutputProperty(X.Conn(), b.output, b.atom, xproto.
+		AtomInteger, 0, 1, false, false).Reply()
+	if err != nil {
+		return 0, err
+	}
+	if r.Format != 32 || len(r.Data) < 4 {
+		return 0, fmt.Errorf("backlight: Not a valid RandR property")
+	}
+	if b.max <= b.min {
+		return 0, nil
+	}
+	v := int32(xgb.Get32(r.Data))
+	return float64(v-b.min) / float64(b.max-b.min), nil
+}
+
+func (b *randrBacklight) set(v float64) error {
+	raw := make([]byte, 4)
+	xgb.Put32(raw, uint32(b.min+int32(math.Round(v*float64(b.max-b.min)))))
+	return randr.ChangeOutputPropertyChecked(X.Conn(), b.output, b.atom,
+		xproto.AtomInteger, 32, xproto.PropModeReplace, 1, raw).Check()
+}
+
+func (b *randrBacklight) changes(ctx context.Context) <-chan struct{} {
+	c := make(chan struct{}, 1)
+	watchOutputProperties(ctx, func() {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	})
+	return c
+}
+
+// backlightControl is a struct that keeps the backlight of a backlight block.
+type backlightControl struct {
+	sync.Mutex
+
+	// The backlight, this is nil until the block opened it.
+	b backlight
+
+	// The brightness step and the minimum brightness, 1 is 100%.
+	step, min float64
+}
+
+// change changes the brightness using `f`, which is called with the current
+// brightness. The brightness is limited to the minimum brightness and 100%.
+func (bc *backlightControl) change(f func(v float64) float64) error {
+	bc.Lock()
+	b := bc.b
+	bc.Unlock()
+	if b == nil {
+		return fmt.Errorf("backlight: Not opened")
+	}
+
+	v, err := b.get()
+	if err != nil {
+		return err
+	}
+	return b.set(math.Max(bc.min, math.Min(1, f(v))))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testBacklight copies the backlight device from the testdata directory to a
+// temporary sysfs, and returns its root.
+func testBacklight(t *testing.T, device string) string {
+	root := t.TempDir()
+	src := filepath.Join("testdata", "sys", "class", "backlight", device)
+	dst := filepath.Join(root, "class", "backlight", device)
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	fl, err := os.ReadDir(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range fl {
+		b, err := os.ReadFile(filepath.Join(src, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dst, f.Name()), b,
+			0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestSysfsBacklight(t *testing.T) {
+	for _, tc := range []struct {
+		device, want string
+		v            float64
+	}{
+		{"", "acpi_video0", 0.2},
+		{"acpi_video0", "acpi_video0", 0.2},
+		{"intel_backlight", "intel_backlight", 0.5},
+	} {
+		b, err := newSysfsBacklight("testdata/sys", tc.device, "")
+		if err != nil {
+			t.Errorf("newSysfsBacklight(%q): %v", tc.device, err)
+			continue
+		}
+		if n := filepath.Base(b.dir); n != tc.want {
+			t.Errorf("newSysfsBacklight(%q): Opened %q, want %q", tc.device,
+				n, tc.want)
+		}
+		v, err := b.get()
+		if err != nil {
+			t.Errorf("get(%s): %v", tc.want, err)
+			continue
+		}
+		if math.Abs(v-tc.v) > 1e-9 {
+			t.Errorf("get(%s) = %v, want %v", tc.want, v, tc.v)
+		}
+	}
+
+	// Devices that don't exist or have no maximum brightness can't be
+	// opened.
+	if _, err := newSysfsBacklight("testdata/sys", "nv_backlight",
+		""); err == nil {
+		t.Error("newSysfsBacklight(nv_backlight): Expected an error")
+	}
+	if _, err := newSysfsBacklight("testdata", "", ""); err == nil {
+		t.Error("newSysfsBacklight(testdata): Expected an error")
+	}
+	root := testBacklight(t, "acpi_video0")
+	if err := os.WriteFile(filepath.Join(root, "class", "backlight",
+		"acpi_video0", "max_brightness"), []byte("0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSysfsBacklight(root, "", ""); err == nil {
+		t.Error("newSysfsBacklight(max 0): Expected an error")
+	}
+}
+
+func TestBacklightActions(t *testing.T) {
+	root := testBacklight(t, "intel_backlight")
+	b, err := newSysfsBacklight(root, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bar := &Bar{store: make(map[string]interface{})}
+	bc := &backlightControl{step: 0.05, min: 0.01}
+
+	// The actions fail until the backlight is opened.
+	f, err := bar.parseAction("backlight up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f(); err == nil {
+		t.Error("backlight up: Expected an error without a backlight block")
+	}
+	bar.setStore("backlight", bc)
+	if err := f(); err == nil {
+		t.Error("backlight up: Expected an error without a backlight")
+	}
+	bc.b = b
+
+	// The brightness is limited to the minimum brightness and 100%.
+	for _, tc := range []struct {
+		action   string
+		from     string
+		want     string
+		parseErr bool
+	}{
+		{"backlight up", "9600", "10560", false},
+		{"backlight down", "9600", "8640", false},
+		{"backlight up", "19000", "19200", false},
+		{"backlight up", "19200", "19200", false},
+		{"backlight down", "300", "192", false},
+		{"backlight down", "0", "192", false},
+		{"backlight set 25", "9600", "4800", false},
+		{"backlight set 150", "9600", "19200", false},
+		{"backlight set -5", "9600", "192", false},
+		{"backlight set", "", "", true},
+		{"backlight set bright", "", "", true},
+		{"backlight left", "", "", true},
+	} {
+		f, err := bar.parseAction(tc.action)
+		if tc.parseErr {
+			if err == nil {
+				t.Errorf("parseAction(%q): Expected an error", tc.action)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAction(%q): %v", tc.action, err)
+			continue
+		}
+
+		fp := filepath.Join(b.dir, "brightness")
+		if err := os.WriteFile(fp, []byte(tc.from+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := f(); err != nil {
+			t.Errorf("%s from %s: %v", tc.action, tc.from, err)
+			continue
+		}
+		if v := readSysfs(b.dir, "brightness"); v != tc.want {
+			t.Errorf("%s from %s: Brightness is %s, want %s", tc.action,
+				tc.from, v, tc.want)
+		}
+	}
+}
+
+func TestBacklightHelper(t *testing.T) {
+	root := testBacklight(t, "intel_backlight")
+	out := filepath.Join(t.TempDir(), "out")
+
+	// Replace the brightness with a directory, which can't be written to,
+	// not even by root.
+	dir := filepath.Join(root, "class", "backlight", "intel_backlight")
+	if err := os.Remove(filepath.Join(dir, "brightness")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "brightness"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a helper the error is returned.
+	b, err := newSysfsBacklight(root, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.set(0.5); err == nil {
+		t.Error("set: Expected an error without a helper")
+	}
+
+	// The helper gets the raw brightness and the percentage.
+	b, err = newSysfsBacklight(root, "", "echo %v %p > "+out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.set(0.25); err != nil {
+		t.Fatal(err)
+	}
+	if v := readSysfs(filepath.Dir(out), "out"); v != "4800 25" {
+		t.Errorf("set: Helper got %q, want %q", v, "4800 25")
+	}
+
+	// A failing helper returns its output.
+	b, err = newSysfsBacklight(root, "", "echo denied; exit 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.set(0.25); err == nil || !strings.Contains(err.Error(),
+		"denied") {
+		t.Errorf("set: Got error %v, want the output of the helper", err)
+	}
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"image"
 	"log"
+	"sync"
 
+	"github.com/AndreKR/multiface"
 	"github.com/BurntSushi/xgb/xproto"
 	"github.com/BurntSushi/xgbutil/xgraphics"
 	"github.com/BurntSushi/xgbutil/xwindow"
 	"github.com/elliotchance/orderedmap"
+	"golang.org/x/image/draw"
 	"golang.org/x/image/font"
 	"golang.org/x/image/math/fixed"
 )
@@ -19,16 +23,33 @@ type Bar struct {
 	win *xwindow.Window
 	img *xgraphics.Image
 
-	// The width and height of the bar.
-	w, h int
+	// The monitor the bar is placed on, and the config it was loaded from.
+	// The monitor is guarded by `mu`, use `getMonitor` to read it from other
+	// goroutines.
+	mon monitor
+	cfg *Config
 
-	// This is a sum of all of the block widths, used to draw a block to the
-	// right of the last block.
-	xsum int
+	// The position, width and height of the bar. These are only changed by
+	// the goroutine that draws the bar, other goroutines should hold `mu` to
+	// read them.
+	x, y, w, h int
+
+	// If the bar is hidden or not.
+	hidden bool
+
+	// The background color of the parts of the bar without blocks.
+	bg xgraphics.BGRA
 
 	// Text drawer.
 	drawer *font.Drawer
 
+	// A mutex that guards the blocks and popups maps and the font face, these
+	// get replaced when the config file is reloaded. It also guards the store.
+	mu sync.RWMutex
+
+	// The font face of the bar and its popups.
+	face *multiface.Face
+
 	// A map that stores the various blocks.
 	blocks *orderedmap.OrderedMap
 
@@ -36,16 +57,44 @@ type Bar struct {
 	popups *orderedmap.OrderedMap
 
 	// Store is an interface to store variables and objects to be used by other
-	// blocks or popups.
+	// blocks or popups, use `getStore` and `setStore` to access it.
 	store map[string]interface{}
 
+	// The context of the current blocks, the function that cancels it, and
+	// a wait group for the update functions of the blocks.
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
 	// A channel where the block should be send to to once its ready to be
 	// redrawn.
 	redraw chan *Block
+
+	// A channel where a block with scrolling text is send to once its text
+	// should scroll.
+	scroll chan *Block
+
+	// A channel where the monitor and config should be send to to move the
+	// bar or reload its config.
+	reload chan target
+
+	// A channel that hides or shows the bar, and a channel that destroys the
+	// bar.
+	hide chan struct{}
+	quit chan struct{}
 }
 
-func initBar(x, y, w, h int) (*Bar, error) {
+// target is a struct with the monitor a bar should be placed on, and the
+// config it should be loaded from. If the config is nil, the bar keeps its
+// current config.
+type target struct {
+	mon monitor
+	cfg *Config
+}
+
+func initBar(m monitor, x, y, w, h int) (*Bar, error) {
 	bar := new(Bar)
+	bar.mon = m
 	var err error
 
 	// Create a window for the bar. This window listens to button press events
@@ -75,14 +124,15 @@ func initBar(x, y, w, h int) (*Bar, error) {
 	}
 	bar.img.XDraw()
 
-	// Set bar width and height.
+	// Set bar position, width and height.
+	bar.x = x
+	bar.y = y
 	bar.w = w
 	bar.h = h
 
-	// Set bar font face.
+	// Set bar text drawer, the font face is set when the config is loaded.
 	bar.drawer = &font.Drawer{
-		Dst:  bar.img,
-		Face: face,
+		Dst: bar.img,
 	}
 
 	// Creat blocks and popups map.
@@ -92,25 +142,270 @@ func initBar(x, y, w, h int) (*Bar, error) {
 	// Create store map.
 	bar.store = make(map[string]interface{})
 
-	// Create redraw channel.
+	// Create redraw, scroll, reload, hide and quit channels.
 	bar.redraw = make(chan *Block)
+	bar.scroll = make(chan *Block)
+	bar.reload = make(chan target)
+	bar.hide = make(chan struct{})
+	bar.quit = make(chan struct{})
+
+	// Listen to mouse events.
+	bar.listenClicks()
 
 	return bar, nil
 }
 
+// load places the bar on monitor `m`, and replaces the blocks and popups of
+// the bar with the ones from the given config. The old blocks are stopped,
+// and the new blocks are drawn and started. This should be called from the
+// goroutine that draws the bar.
+func (bar *Bar) load(m monitor, cfg *Config) error {
+	bm, err := bar.initBlocks(cfg.Blocks, m)
+	if err != nil {
+		return err
+	}
+	pm, err := bar.initPopups(cfg.Popups)
+	if err != nil {
+		return err
+	}
+	r := barRect(cfg.Bar, m)
+	bg := xgraphics.BGRA{A: 0xFF}
+	if cfg.Bar.Bg != "" {
+		if bg, err = parseColor(cfg.Bar.Bg); err != nil {
+			return err
+		}
+	}
+
+	// Stop the old blocks and wait for them to return, and close the old
+	// popups.
+	if bar.cancel != nil {
+		bar.cancel()
+		bar.wg.Wait()
+	}
+	for _, key := range bar.popups.Keys() {
+		if popup := bar.popup(key.(string)); popup.open {
+			popup.destroy()
+		}
+	}
+
+	// Apply the new font and geometry.
+	bar.drawer.Face = cfg.face
+	if r != image.Rect(bar.x, bar.y, bar.x+bar.w, bar.y+bar.h) {
+		if err := bar.resize(r.Min.X, r.Min.Y, r.Dx(), r.Dy()); err != nil {
+			return err
+		}
+	}
+
+	// Replace the blocks and popups.
+	bar.mu.Lock()
+	bar.mon = m
+	bar.blocks = bm
+	bar.popups = pm
+	bar.face = cfg.face
+	bar.bg = bg
+	bar.cfg = cfg
+	bar.ctx, bar.cancel = context.WithCancel(context.Background())
+	bar.mu.Unlock()
+
+	// Reserve space for the bar.
+	if err := bar.strut(); err != nil {
+		return err
+	}
+
+	// Draw the new blocks.
+	for _, key := range bm.Keys() {
+		bar.block(key.(string)).ctx = bar.ctx
+	}
+	bar.drawBlocks()
+
+	// Run update functions.
+	for _, key := range bm.Keys() {
+		block := bar.block(key.(string))
+		bar.wg.Add(1)
+		go func() {
+			defer bar.wg.Done()
+			block.update(block.ctx)
+		}()
+	}
+
+	return nil
+}
+
+// resize moves and resizes the bar window, and recreates the bar image.
+func (bar *Bar) resize(x, y, w, h int) error {
+	bar.win.MoveResize(x, y, w, h)
+
+	// Recreate the bar image.
+	img := xgraphics.New(X, image.Rect(0, 0, w, h))
+	if err := img.XSurfaceSet(bar.win.Id); err != nil {
+		return err
+	}
+	bar.img.Destroy()
+	bar.img = img
+	bar.drawer.Dst = img
+
+	// Set bar position, width and height.
+	bar.mu.Lock()
+	bar.x = x
+	bar.y = y
+	bar.w = w
+	bar.h = h
+	bar.mu.Unlock()
+
+	return nil
+}
+
+// strut updates the space reserved for the bar.
+func (bar *Bar) strut() error {
+	return setStrut(bar.win.Id, image.Rect(bar.x, bar.y, bar.x+bar.w,
+		bar.y+bar.h), bar.cfg.Bar.Bottom, bar.hidden)
+}
+
+// toggle hides the bar if it is shown, and shows the bar if it is hidden.
+// This should be called from the goroutine that draws the bar.
+func (bar *Bar) toggle() error {
+	bar.hidden = !bar.hidden
+	if bar.hidden {
+		for _, key := range bar.popups.Keys() {
+			if popup := bar.popup(key.(string)); popup.open {
+				popup.destroy()
+			}
+		}
+
+		// Stop scrolling the text of the blocks, nobody can see it.
+		for _, key := range bar.blocks.Keys() {
+			bar.block(key.(string)).scroll.halt()
+		}
+
+		bar.win.Unmap()
+	} else {
+		bar.win.Map()
+		bar.win.Move(bar.x, bar.y)
+
+		// Redraw the bar, this starts scrolling the text again.
+		bar.drawBlocks()
+	}
+
+	return bar.strut()
+}
+
+// destroy stops the blocks, closes the popups and destroys the bar window.
+// This should be called from the goroutine that draws the bar.
+func (bar *Bar) destroy() {
+	// Wait for the blocks to stop, some blocks have to clean up windows
+	// inside the bar window.
+	bar.cancel()
+	bar.wg.Wait()
+	for _, key := range bar.popups.Keys() {
+		if popup := bar.popup(key.(string)); popup.open {
+			popup.destroy()
+		}
+	}
+
+	bar.img.Destroy()
+	bar.win.Destroy()
+}
+
+// getStore returns the value stored under `key`, or nil.
+func (bar *Bar) getStore(key string) interface{} {
+	bar.mu.RLock()
+	defer bar.mu.RUnlock()
+
+	return bar.store[key]
+}
+
+// setStore stores `v` under `key`.
+func (bar *Bar) setStore(key string, v interface{}) {
+	bar.mu.Lock()
+	defer bar.mu.Unlock()
+
+	bar.store[key] = v
+}
+
+// getMonitor returns the monitor the bar is placed on.
+func (bar *Bar) getMonitor() monitor {
+	bar.mu.RLock()
+	defer bar.mu.RUnlock()
+
+	return bar.mon
+}
+
+// getFace returns the font face of the bar.
+func (bar *Bar) getFace() *multiface.Face {
+	bar.mu.RLock()
+	defer bar.mu.RUnlock()
+
+	return bar.face
+}
+
+// draw draws the block, or lays out and draws all blocks if the block doesn't
+// fit its text anymore. This should be called from the goroutine that draws
+// the bar.
 func (bar *Bar) draw(block *Block) error {
-	// Calculate the required x coordinate for the different aligments.
+	// Reflow the bar if the block doesn't fit its text anymore.
+	block.mu.Lock()
+	if block.ctx != nil && block.ctx.Err() == nil && block.size == 'f' &&
+		bar.fitWidth(block) != block.w {
+		block.mu.Unlock()
+		bar.drawBlocks()
+		return nil
+	}
+	defer block.mu.Unlock()
+
+	return bar.drawBlock(block)
+}
+
+// drawBlock draws the block inside its image, the block should be locked.
+func (bar *Bar) drawBlock(block *Block) error {
+	// Skip blocks that have been removed from the bar.
+	if block.ctx == nil || block.ctx.Err() != nil {
+		return nil
+	}
+
+	// Skip blocks without any width, these are hidden.
+	if block.img == nil {
+		block.scroll.halt()
+		if block.tray != nil {
+			block.tray.place(image.Rectangle{})
+		}
+		return nil
+	}
+
+	// Draw the tray, icon, items or graph instead of the text if the block has them.
+	if block.tray != nil {
+		return bar.drawTray(block)
+	}
+	if block.icon != nil {
+		return bar.drawIcon(block)
+	}
+	if block.items != nil {
+		return bar.drawItems(block)
+	}
+	if block.graph != nil {
+		return bar.drawGraph(block)
+	}
+
+	// Parse the markup of the text.
+	rl := parseMarkup(block.txt, block.fg, block.bg)
+
+	// Calculate the required x coordinate for the different aligments. If
+	// the text scrolls, it is drawn inside the padding of the block only.
 	var x int
-	tw := bar.drawer.MeasureString(block.txt).Round()
-	switch block.align {
-	case 'l':
-		x = block.x
-	case 'c':
+	dst := block.img
+	tw := bar.textWidth(rl)
+	switch {
+	case bar.scrolling(block, tw):
+		x = block.x + block.pad - block.scroll.off
+		if sub, ok := block.img.SubImage(image.Rect(block.x+block.pad, 0,
+			block.x+block.w-block.pad, bar.h)).(*xgraphics.Image); ok {
+			dst = sub
+		}
+	case block.align == 'l':
+		x = block.x + block.pad
+	case block.align == 'c':
 		x = block.x + ((block.w / 2) - (tw / 2))
-	case 'r':
-		x = (block.x + block.w) - tw
-	case 'a':
-		x = (bar.w / 2) - (tw / 2)
+	case block.align == 'r':
+		x = (block.x + block.w) - tw - block.pad
 	default:
 		return fmt.Errorf("draw %#U: Not a valid aligment rune", block.align)
 	}
@@ -119,34 +414,243 @@ func (bar *Bar) draw(block *Block) error {
 
 	// Color the background.
 	block.img.For(func(cx, cy int) xgraphics.BGRA {
-		// XXX: Hack for music block.
-		if block.w == 660 {
-			if cx < x+block.xoff {
-				return xgraphics.BGRA{B: 103, G: 89, R: 68, A: 0xFF}
-			}
+		return block.bg
+	})
+
+	// Store the painted rectangles.
+	block.rect = block.img.Bounds()
+	block.trect = image.Rect(x, 0, x+tw, bar.h).Intersect(dst.Bounds())
+	block.regions = nil
+
+	// Draw the text inside the block only.
+	bar.drawer.Dst = dst
+	for _, r := range rl {
+		rw := r.off + bar.drawer.MeasureString(r.txt).Round()
+
+		// Color the background of the run.
+		if r.bg != block.bg {
+			fill(dst, image.Rect(x, 0, x+rw, bar.h), r.bg)
 		}
 
+		// Set foreground color, and draw the text.
+		bar.drawer.Src = image.NewUniform(r.fg)
+		bar.drawer.Dot = fixed.P(x+r.off, bar.baseline())
+		bar.drawer.DrawString(r.txt)
+
+		// Draw the underline.
+		if r.ul {
+			fill(dst, image.Rect(x+r.off, bar.h-2, x+rw, bar.h-1), r.ulc)
+		}
+
+		// Store the clickable region.
+		if r.actions != nil {
+			block.regions = append(block.regions, region{
+				rect:    image.Rect(x, 0, x+rw, bar.h).Intersect(dst.Bounds()),
+				actions: r.actions,
+			})
+		}
+
+		x += rw
+	}
+
+	// Redraw the block only.
+	block.img.XDraw()
+	xproto.ClearArea(X.Conn(), false, bar.win.Id, int16(block.x), 0, uint16(
+		block.w), uint16(bar.h))
+
+	return nil
+}
+
+// drawIcon draws the icon of the block, centered in the block and alpha
+// blended onto its background. The block should be locked.
+func (bar *Bar) drawIcon(block *Block) error {
+	block.scroll.halt()
+
+	// Color the background.
+	block.img.For(func(cx, cy int) xgraphics.BGRA {
 		return block.bg
 	})
 
-	// Set foreground color.
-	bar.drawer.Src = image.NewUniform(block.fg)
+	// Center the icon.
+	ib := block.icon.Bounds()
+	x := block.x + (block.w-ib.Dx())/2
+	y := (bar.h - ib.Dy()) / 2
+	r := image.Rect(x, y, x+ib.Dx(), y+ib.Dy()).Intersect(block.img.Bounds())
+
+	// Store the painted rectangles.
+	block.rect = block.img.Bounds()
+	block.trect = r
+	block.regions = nil
 
-	// Draw the text.
-	bar.drawer.Dot = fixed.P(x, 16)
-	bar.drawer.DrawString(block.txt)
+	// Draw the icon.
+	draw.Draw(block.img, r, block.icon, ib.Min.Add(r.Min.Sub(image.Pt(x, y))),
+		draw.Over)
 
-	// Redraw the bar.
+	// Redraw the block only.
 	block.img.XDraw()
-	bar.img.XPaint(bar.win.Id)
+	xproto.ClearArea(X.Conn(), false, bar.win.Id, int16(block.x), 0, uint16(
+		block.w), uint16(bar.h))
+
+	return nil
+}
+
+// drawTray draws the background of the block, and places the system tray
+// icons on top of it. The block should be locked.
+func (bar *Bar) drawTray(block *Block) error {
+	block.scroll.halt()
+
+	// Color the background.
+	block.img.For(func(cx, cy int) xgraphics.BGRA {
+		return block.bg
+	})
+
+	// Store the painted rectangles.
+	block.rect = block.img.Bounds()
+	block.trect = block.rect
+	block.regions = nil
+
+	// Redraw the block, and place the icons on top of it.
+	block.img.XDraw()
+	xproto.ClearArea(X.Conn(), false, bar.win.Id, int16(block.x), 0, uint16(
+		block.w), uint16(bar.h))
+	block.tray.place(image.Rect(block.x+block.pad+block.xoff, 0, block.x+
+		block.w-block.pad+block.xoff, bar.h))
+
+	return nil
+}
+
+// drawGraph draws the graph of the block, inside the padding of the block.
+// The block should be locked.
+func (bar *Bar) drawGraph(block *Block) error {
+	block.scroll.halt()
+
+	// Color the background.
+	block.img.For(func(cx, cy int) xgraphics.BGRA {
+		return block.bg
+	})
+
+	// Store the painted rectangles.
+	block.rect = block.img.Bounds()
+	block.trect = block.rect
+	block.regions = nil
+
+	// Draw the graph, leaving some space above and below it.
+	const inset = 6
+	r := image.Rect(block.x+block.pad, inset, block.x+block.w-block.pad,
+		bar.h-inset)
+	switch block.graph.mode {
+	case 's':
+		sparkline(block.img, r, block.graph.values, block.fg)
+	case 'b':
+		if n := len(block.graph.values); n > 0 {
+			hbar(block.img, r, block.graph.values[n-1], block.fg)
+		}
+	}
+
+	// Redraw the block only.
+	block.img.XDraw()
+	xproto.ClearArea(X.Conn(), false, bar.win.Id, int16(block.x), 0, uint16(
+		block.w), uint16(bar.h))
+
+	return nil
+}
+
+// drawItems draws the items of the block. Every item gets an equal share of
+// the width of the block, and is padded using the padding of the block. The
+// block should be locked.
+func (bar *Bar) drawItems(block *Block) error {
+	block.scroll.halt()
+
+	// Color the background.
+	block.img.For(func(cx, cy int) xgraphics.BGRA {
+		return block.bg
+	})
+
+	// Store the painted rectangles.
+	block.rect = block.img.Bounds()
+	block.trect = block.rect
+	block.regions = nil
+
+	// The space between the icon and the text of an item.
+	const gap = 4
+
+	x := block.x
+	for i, it := range block.items {
+		// Give the remaining pixels to the last item.
+		iw := block.w / len(block.items)
+		if i == len(block.items)-1 {
+			iw = block.x + block.w - x
+		}
+		r := image.Rect(x, 0, x+iw, bar.h)
+		x += iw
+
+		// Color the background of the item.
+		if it.bg != block.bg {
+			fill(block.img, r, it.bg)
+		}
+
+		// Draw the icon, vertically centered.
+		tx := r.Min.X + block.pad + block.xoff
+		if it.icon != nil {
+			ib := it.icon.Bounds()
+			y := (bar.h - ib.Dy()) / 2
+			ir := image.Rect(tx, y, tx+ib.Dx(), y+ib.Dy()).Intersect(r)
+			draw.Draw(block.img, ir, it.icon, ib.Min.Add(ir.Min.Sub(image.Pt(tx,
+				y))), draw.Over)
+			tx += ib.Dx() + gap
+		}
+
+		// Draw the truncated text inside the item only.
+		if sub, ok := block.img.SubImage(r).(*xgraphics.Image); ok {
+			bar.drawer.Dst = sub
+			bar.drawer.Src = image.NewUniform(it.fg)
+			bar.drawer.Dot = fixed.P(tx, bar.baseline())
+			bar.drawer.DrawString(truncate(bar.drawer.Face, it.txt, r.Max.X-
+				block.pad-tx, 'e'))
+		}
+
+		// Store the clickable region.
+		if it.actions != nil {
+			block.regions = append(block.regions, region{
+				rect:    r,
+				actions: it.actions,
+			})
+		}
+	}
+
+	// Redraw the block only.
+	block.img.XDraw()
+	xproto.ClearArea(X.Conn(), false, bar.win.Id, int16(block.x), 0, uint16(
+		block.w), uint16(bar.h))
 
 	return nil
 }
 
 func (bar *Bar) listen() {
 	for {
-		if err := bar.draw(<-bar.redraw); err != nil {
-			log.Fatalln(err)
+		select {
+		case block := <-bar.redraw:
+			if err := bar.draw(block); err != nil {
+				log.Fatalln(err)
+			}
+		case block := <-bar.scroll:
+			if err := bar.step(block); err != nil {
+				log.Fatalln(err)
+			}
+		case t := <-bar.reload:
+			if t.cfg == nil {
+				t.cfg = bar.cfg
+			}
+			if err := bar.load(t.mon, t.cfg); err != nil {
+				log.Println(err)
+			}
+		case <-bar.hide:
+			if err := bar.toggle(); err != nil {
+				log.Println(err)
+			}
+		case <-bar.quit:
+			bar.destroy()
+			return
 		}
 	}
 }
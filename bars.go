@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+)
+
+// bars is a map with the bar of every monitor, the key is the name of the
+// monitor.
+var bars = make(map[string]*Bar)
+
+// syncBars makes sure every monitor selected in the config has a bar. Bars
+// are created for new monitors, moved for changed monitors and destroyed for
+// removed monitors. If `reload` is true, existing bars reload the config.
+// This should be called from the main goroutine.
+func syncBars(cfg *Config, reload bool) error {
+	ml, err := barMonitors(cfg.Bar)
+	if err != nil {
+		return err
+	}
+
+	// Load the font face once for all bars.
+	if cfg.face == nil {
+		if cfg.face, err = initFace(cfg.Bar.Fonts); err != nil {
+			return err
+		}
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range ml {
+		seen[m.name] = true
+
+		// Move or reload the existing bar, both at once if the monitor
+		// changed and the config is reloaded.
+		if bar, ok := bars[m.name]; ok {
+			t := target{mon: m}
+			if reload {
+				t.cfg = cfg
+			}
+			if reload || bar.getMonitor() != m {
+				bar.reload <- t
+			}
+			continue
+		}
+
+		// Create a new bar, and load its blocks and popups.
+		r := barRect(cfg.Bar, m)
+		bar, err := initBar(m, r.Min.X, r.Min.Y, r.Dx(), r.Dy())
+		if err != nil {
+			return err
+		}
+		if err := bar.load(m, cfg); err != nil {
+			bar.img.Destroy()
+			bar.win.Destroy()
+			return err
+		}
+		bars[m.name] = bar
+
+		// Listen for redraw, scroll, reload, hide and quit events.
+		go bar.listen()
+	}
+
+	// Destroy the bars of removed monitors.
+	for n, bar := range bars {
+		if !seen[n] {
+			bar.quit <- struct{}{}
+			delete(bars, n)
+		}
+	}
+
+	if len(bars) == 0 {
+		log.Println("bar: No monitor matches the configured monitors")
+	}
+
+	return nil
+}
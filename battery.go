@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// battery is a struct with information about one or more batteries.
+type battery struct {
+	// The remaining capacity in percent.
+	capacity int
+
+	// The status, this can be `Charging`, `Discharging`, `Full` or
+	// `Unknown`.
+	status string
+
+	// The time until the battery is empty or full, zero if unknown.
+	remaining time.Duration
+}
+
+// readBatteries reads the batteries from the `power_supply` class in sysfs
+// below `root`, and combines them into one battery. If `names` isn't empty
+// only the batteries with the given names are read, for example `BAT0`.
+func readBatteries(root string, names []string) (battery, error) {
+	dl, err := filepath.Glob(filepath.Join(root, "class", "power_supply",
+		"*"))
+	if err != nil {
+		return battery{}, err
+	}
+
+	var now, full, rate float64
+	var caps, n int
+	st := make(map[string]bool)
+	for _, d := range dl {
+		if readSysfs(d, "type") != "Battery" {
+			continue
+		}
+		if len(names) > 0 && !contains(names, filepath.Base(d)) {
+			continue
+		}
+		n++
+
+		// Read the status and capacity.
+		st[readSysfs(d, "status")] = true
+		if c, err := strconv.Atoi(readSysfs(d, "capacity")); err == nil {
+			caps += c
+		}
+
+		// Batteries report either energy in µWh and power in µW, or charge in
+		// µAh and current in µA.
+		for _, p := range [][3]string{
+			{"energy_now", "energy_full", "power_now"},
+			{"charge_now", "charge_full", "current_now"},
+		} {
+			bn, err := strconv.ParseFloat(readSysfs(d, p[0]), 64)
+			if err != nil {
+				continue
+			}
+			bf, _ := strconv.ParseFloat(readSysfs(d, p[1]), 64)
+			br, _ := strconv.ParseFloat(readSysfs(d, p[2]), 64)
+
+			now += bn
+			full += bf
+			if br < 0 {
+				br = -br
+			}
+			rate += br
+			break
+		}
+	}
+	if n == 0 {
+		return battery{}, fmt.Errorf("battery %q: No batteries found", root)
+	}
+
+	// Combine the batteries.
+	b := battery{capacity: caps / n, status: "Unknown"}
+	if full > 0 {
+		b.capacity = int(now / full * 100)
+	}
+	if b.capacity > 100 {
+		b.capacity = 100
+	}
+	switch {
+	case st["Discharging"]:
+		b.status = "Discharging"
+		if rate > 0 {
+			b.remaining = time.Duration(now / rate * float64(time.Hour))
+		}
+	case st["Charging"]:
+		b.status = "Charging"
+		if rate > 0 {
+			b.remaining = time.Duration((full - now) / rate * float64(time.
+				Hour))
+		}
+	case st["Full"] || st["Not charging"]:
+		b.status = "Full"
+	}
+
+	return b, nil
+}
+
+// readSysfs reads a sysfs attribute, it returns an empty string if the
+// attribute can't be read.
+func readSysfs(dir, name string) string {
+	b, err := os.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReadBatteries(t *testing.T) {
+	for _, tc := range []struct {
+		names []string
+		want  battery
+	}{
+		{nil, battery{capacity: 53, status: "Discharging",
+			remaining: 2*time.Hour + 18*time.Minute}},
+		{[]string{"BAT0"}, battery{capacity: 50, status: "Discharging",
+			remaining: 2 * time.Hour}},
+		{[]string{"BAT1"}, battery{capacity: 100, status: "Full"}},
+	} {
+		b, err := readBatteries("testdata/sys", tc.names)
+		if err != nil {
+			t.Errorf("readBatteries(%q): %v", tc.names, err)
+			continue
+		}
+		b.remaining = b.remaining.Round(time.Second)
+		if b != tc.want {
+			t.Errorf("readBatteries(%q) = %+v, want %+v", tc.names, b,
+				tc.want)
+		}
+	}
+
+	// The mains adapter isn't a battery.
+	if _, err := readBatteries("testdata/sys", []string{"AC"}); err == nil {
+		t.Error("readBatteries(AC): Expected an error")
+	}
+}
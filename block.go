@@ -1,23 +1,44 @@
 package main
 
 import (
+	"context"
 	"image"
+	"log"
+	"sync"
 
 	"github.com/BurntSushi/xgb/xproto"
 	"github.com/BurntSushi/xgbutil"
 	"github.com/BurntSushi/xgbutil/xevent"
 	"github.com/BurntSushi/xgbutil/xgraphics"
-	"golang.org/x/image/font"
 )
 
 // Block is a struct with information about a block.
 type Block struct {
+	// A mutex that guards the text, colors, icon, items and graph of the
+	// block, these are set by its update function. It also guards the
+	// location, width and image of the block and the rectangles and regions
+	// it painted, these are set by the goroutine that draws the bar.
+	mu sync.Mutex
+
 	// The sub-image that represents the block.
 	img *xgraphics.Image
 
-	// The x coordinate and width of the block.
+	// The x coordinate and width of the block, these are calculated by
+	// `layout`.
 	x, w int
 
+	// The region of the bar the block is placed in, this can be `l` for the
+	// left, `c` for the center and `r` for the right region.
+	region rune
+
+	// How the width of the block is decided, this can be `x` for a fixed width
+	// of `fw` pixels, `f` to fit the text and `*` to fill the remaining space.
+	size rune
+	fw   int
+
+	// The padding on both sides of the text.
+	pad int
+
 	// Additional x offset to further tweak the location of the text.
 	xoff int
 
@@ -25,77 +46,304 @@ type Block struct {
 	txt string
 
 	// The aligment of the text, this can be `l` for left aligment, `c` for
-	// center aligment, `r` for right aligment and `a` for absolute center
-	// aligment.
+	// center aligment and `r` for right aligment.
 	align rune
 
 	// The foreground and background colors.
 	bg, fg xgraphics.BGRA
 
+	// The rectangles the block painted the last time it was drawn, its
+	// background and its text. These are used to check if a click landed
+	// inside the block.
+	rect, trect image.Rectangle
+
+	// This boolean decides if only the text of the block is clickable, instead
+	// of the whole block.
+	clickText bool
+
+	// The scrolling text of the block, if this is nil text that doesn't fit
+	// the block isn't scrolled.
+	scroll *marquee
+
+	// The clickable regions the text of the block defined using markup, the
+	// last time it was drawn.
+	regions []region
+
+	// An icon that is drawn centered in the block instead of its text, this
+	// is nil if the text should be drawn.
+	icon image.Image
+
+	// A list of items that is drawn instead of the text of the block, this is
+	// nil if the text should be drawn.
+	items []item
+
+	// The system tray whose icons are placed in the block instead of its text,
+	// this is nil if the text should be drawn.
+	tray *tray
+
+	// A graph that is drawn instead of the text of the block, this is nil if
+	// the text should be drawn.
+	graph *graph
+
 	// This boolean decides if the block is an invisible "script block", that
 	// doesn't draw anything to the bar, only executes the `update` function.
 	script bool
 
+	// The context of the block, this is cancelled once the block is removed
+	// from the bar, for example when the config file is reloaded.
+	ctx context.Context
+
 	// The fuction that updates the block, this will be executes as a goroutine.
-	update func()
+	// It should return once `ctx` is done.
+	update func(ctx context.Context)
 
 	// A map with functions to execute on button events.
 	actions map[xproto.Button]func() error
 }
 
+// region is a struct with information about a clickable region of a block.
+type region struct {
+	// The rectangle of the region.
+	rect image.Rectangle
+
+	// A map with actions to execute on button events, see `parseAction`.
+	actions map[xproto.Button]string
+}
+
+// item is a struct with information about an item of a block that displays
+// a list of items, for example a window in the taskbar. Every item gets an
+// equal share of the width of the block.
+type item struct {
+	// The icon before the text of the item, this can be nil.
+	icon image.Image
+
+	// The text of the item, this is truncated to fit the item.
+	txt string
+
+	// The foreground and background colors.
+	fg, bg xgraphics.BGRA
+
+	// A map with actions to execute on button events inside the item, see
+	// `parseAction`.
+	actions map[xproto.Button]string
+}
+
+// graph is a struct with information about a graph of values between zero
+// and one.
+type graph struct {
+	// The type of graph, this can be `s` for a sparkline with the newest
+	// value on the right, or `b` for a bar of the newest value.
+	mode rune
+
+	// The values, the newest value is last.
+	values []float64
+}
+
+// paint sends the block to the redraw channel, unless the block has been
+// removed from the bar.
+func (bar *Bar) paint(block *Block) {
+	select {
+	case bar.redraw <- block:
+	case <-block.ctx.Done():
+	}
+}
+
+// drawBlocks lays out and draws all blocks. This should be called from the
+// goroutine that draws the bar.
 func (bar *Bar) drawBlocks() {
+	bar.layout()
+
+	// Clear the bar.
+	bar.img.For(func(cx, cy int) xgraphics.BGRA {
+		return bar.bg
+	})
+
+	// Draw blocks.
 	for _, key := range bar.blocks.Keys() {
 		block := bar.block(key.(string))
+		if block.script {
+			continue
+		}
+
+		block.mu.Lock()
+		err := bar.drawBlock(block)
+		block.mu.Unlock()
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
 
-		// Run update function.
-		go block.update()
+	// Redraw the bar.
+	bar.img.XDraw()
+	bar.img.XPaint(bar.win.Id)
+}
 
-		// Check if the block is a script block, if that is the case, we only
-		// execute the `upload` function.
+// layout calculates the position and width of all blocks, and initializes
+// their images. The left region starts at the left edge of the bar, the right
+// region ends at the right edge of the bar and the center region is centered
+// as long as it doesn't overlap the other regions.
+func (bar *Bar) layout() {
+	var bl []*Block
+	var fill int
+	free := bar.w
+	for _, key := range bar.blocks.Keys() {
+		block := bar.block(key.(string))
+
+		// Script blocks don't draw anything.
 		if block.script {
 			continue
 		}
+		bl = append(bl, block)
 
-		// Initialize block image.
-		block.img = bar.img.SubImage(image.Rect(bar.xsum, 0, bar.xsum+block.
-			w, bar.h)).(*xgraphics.Image)
+		// Calculate the width of the block.
+		block.mu.Lock()
+		switch block.size {
+		case 'f':
+			block.w = bar.fitWidth(block)
+		case '*':
+			block.w = 0
+			fill++
+		default:
+			block.w = block.fw
+		}
+		free -= block.w
+		block.mu.Unlock()
+	}
 
-		// set the block location.
-		block.x = bar.xsum
+	// Divide the remaining space between the blocks that fill it.
+	rw := make(map[rune]int)
+	for _, block := range bl {
+		block.mu.Lock()
+		if block.size == '*' && free > 0 {
+			block.w = free / fill
+			free -= block.w
+			fill--
+		}
+		rw[block.region] += block.w
+		block.mu.Unlock()
+	}
+
+	// Calculate the x coordinate of each region.
+	rx := map[rune]int{
+		'l': 0,
+		'c': (bar.w / 2) - (rw['c'] / 2),
+		'r': bar.w - rw['r'],
+	}
+	if rx['c']+rw['c'] > rx['r'] {
+		rx['c'] = rx['r'] - rw['c']
+	}
+	if rx['c'] < rw['l'] {
+		rx['c'] = rw['l']
+	}
+
+	for _, block := range bl {
+		block.mu.Lock()
+
+		// Set the block location.
+		block.x = rx[block.region]
+		rx[block.region] += block.w
+
+		// Initialize block image, blocks that don't fit the bar only get the
+		// part of the image that is inside of the bar.
+		block.img = nil
+		block.rect = image.Rectangle{}
+		block.trect = image.Rectangle{}
+		if img, ok := bar.img.SubImage(image.Rect(block.x, 0, block.x+block.w,
+			bar.h)).(*xgraphics.Image); ok {
+			block.img = img
+		}
+
+		block.mu.Unlock()
+	}
+}
+
+// fitWidth returns the width a block needs to fit its text. The block should
+// be locked.
+func (bar *Bar) fitWidth(block *Block) int {
+	if block.tray != nil {
+		return block.tray.width(bar.h) + (block.pad * 2)
+	}
+	if block.icon != nil {
+		return block.icon.Bounds().Dx() + (block.pad * 2)
+	}
+	return bar.textWidth(parseMarkup(block.txt, block.fg, block.bg)) + (block.
+		pad * 2)
+}
 
-		// Add the width of this block to the xsum.
-		bar.xsum += block.w
+// freeWidth returns the width of the bar that isn't taken by the other blocks
+// with a fixed width or a width that fits their text, as they were last laid
+// out. Blocks that fill the remaining space don't count.
+func (bar *Bar) freeWidth(block *Block) int {
+	bar.mu.RLock()
+	bm := bar.blocks
+	w := bar.w
+	bar.mu.RUnlock()
 
-		// Draw block.
-		bar.redraw <- block
+	for _, key := range bm.Keys() {
+		v, _ := bm.Get(key)
+		b := v.(*Block)
+		if b == block || b.script || b.size == '*' {
+			continue
+		}
+
+		b.mu.Lock()
+		w -= b.w
+		b.mu.Unlock()
 	}
+	return w
+}
 
-	// Listen to mouse events and execute the required function.
+// textWidth returns the width of the given runs.
+func (bar *Bar) textWidth(rl []run) int {
+	var w int
+	for _, r := range rl {
+		w += r.off + bar.drawer.MeasureString(r.txt).Round()
+	}
+	return w
+}
+
+// baseline returns the y coordinate of the baseline of text that is vertically
+// centered in the bar, using the ascent and descent of the font face.
+func (bar *Bar) baseline() int {
+	m := bar.drawer.Face.Metrics()
+	return (bar.h + m.Ascent.Round() - m.Descent.Round()) / 2
+}
+
+// listenClicks listens to mouse events and executes the required function.
+func (bar *Bar) listenClicks() {
 	xevent.ButtonPressFun(func(_ *xgbutil.XUtil, ev xevent.ButtonPressEvent) {
-		for _, k := range bar.blocks.Keys() {
-			block := bar.block(k.(string))
-
-			// Check if clicked inside the block, if not, return.
-			switch k {
-			// XXX: Hack for music block.
-			case "music":
-				tw := font.MeasureString(face, block.txt).Round()
-				if ev.EventX < int16(block.x+(block.w-tw+(block.xoff*2))) || ev.
-					EventX > int16(block.x+block.w) {
-					continue
-				}
-			// XXX: Hack for clock block.
-			case "clock":
-				tw := bar.drawer.MeasureString(block.txt).Ceil()
-				if ev.EventX < int16(((bar.w/2)-(tw/2))-13) || ev.
-					EventX > int16(((bar.w/2)+(tw/2))+13) {
-					continue
-				}
-			default:
-				if ev.EventX < int16(block.x) || ev.EventX > int16(block.x+block.
-					w) {
+		bar.mu.RLock()
+		bm := bar.blocks
+		bar.mu.RUnlock()
+
+		for _, k := range bm.Keys() {
+			i, _ := bm.Get(k)
+			block := i.(*Block)
+
+			// Get the painted rectangle and the action of the clicked region,
+			// these are replaced every time the block is drawn.
+			block.mu.Lock()
+			r := block.rect
+			if block.clickText {
+				r = block.trect
+			}
+			a, ok := block.regionAction(ev)
+			block.mu.Unlock()
+
+			// Check if clicked inside the block, if not, continue.
+			if !image.Pt(int(ev.EventX), int(ev.EventY)).In(r) {
+				continue
+			}
+
+			// Execute the action of the clicked region if there is one.
+			if ok {
+				f, err := bar.parseAction(a)
+				if err != nil {
+					log.Println(err)
 					continue
 				}
+				go f()
+				continue
 			}
 
 			// Execute the function as specified.
@@ -106,7 +354,29 @@ func (bar *Bar) drawBlocks() {
 	}).Connect(X, bar.win.Id)
 }
 
+// regionAction returns the action of the innermost clicked region. The block
+// should be locked.
+func (block *Block) regionAction(ev xevent.ButtonPressEvent) (string, bool) {
+	pt := image.Pt(int(ev.EventX), int(ev.EventY))
+	for i := len(block.regions) - 1; i >= 0; i-- {
+		r := block.regions[i]
+		if !pt.In(r.rect) {
+			continue
+		}
+		if a, ok := r.actions[ev.Detail]; ok {
+			return a, true
+		}
+	}
+	return "", false
+}
+
 func (bar *Bar) block(key string) *Block {
-	i, _ := bar.blocks.Get(key)
+	bar.mu.RLock()
+	defer bar.mu.RUnlock()
+
+	i, ok := bar.blocks.Get(key)
+	if !ok {
+		return nil
+	}
 	return i.(*Block)
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"image"
+	"strconv"
+	"testing"
+
+	"github.com/BurntSushi/xgbutil/xgraphics"
+	"github.com/elliotchance/orderedmap"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestBaseline(t *testing.T) {
+	// The glyphs of this face have an ascent of 11 and a descent of 2
+	// pixels.
+	d := &font.Drawer{Face: basicfont.Face7x13}
+
+	for _, tc := range []struct {
+		h, want int
+	}{
+		{13, 11},
+		{29, 19},
+		{20, 14},
+		{8, 8},
+	} {
+		bar := &Bar{h: tc.h, drawer: d}
+		if y := bar.baseline(); y != tc.want {
+			t.Errorf("baseline() with a height of %d = %d, want %d", tc.h, y,
+				tc.want)
+		}
+	}
+}
+
+// testBar returns a bar with a width of `w` pixels and the given blocks, the
+// blocks are named after their index.
+func testBar(w int, bl ...*Block) *Bar {
+	bar := &Bar{
+		w:      w,
+		h:      20,
+		blocks: orderedmap.NewOrderedMap(),
+		drawer: &font.Drawer{Face: basicfont.Face7x13},
+	}
+	bar.img = &xgraphics.Image{
+		Pix:    make([]uint8, 4*w*bar.h),
+		Stride: 4 * w,
+		Rect:   image.Rect(0, 0, w, bar.h),
+	}
+	for i, block := range bl {
+		bar.blocks.Set(strconv.Itoa(i), block)
+	}
+	return bar
+}
+
+func TestLayout(t *testing.T) {
+	// fixed returns a block with a fixed width in the given region.
+	fixed := func(r rune, w int) *Block {
+		return &Block{region: r, size: 'x', fw: w}
+	}
+	// fill returns a block that fills the remaining space in the given
+	// region.
+	fill := func(r rune) *Block {
+		return &Block{region: r, size: '*'}
+	}
+
+	for _, tc := range []struct {
+		name string
+		w    int
+		bl   []*Block
+
+		// The x coordinate and the width of every block.
+		want [][2]int
+	}{
+		{"left", 200, []*Block{fixed('l', 50), {region: 'l', size: 'f',
+			txt: "abc", pad: 2}}, [][2]int{{0, 50}, {50, 25}}},
+		{"center", 200, []*Block{fixed('c', 40), fixed('c', 20)},
+			[][2]int{{70, 40}, {110, 20}}},
+		{"right", 200, []*Block{fixed('r', 30), fixed('r', 20)},
+			[][2]int{{150, 30}, {180, 20}}},
+		{"all regions", 200, []*Block{fixed('r', 30), fixed('c', 20),
+			fixed('l', 10)}, [][2]int{{170, 30}, {90, 20}, {0, 10}}},
+		{"script", 200, []*Block{{script: true}, fixed('l', 10)},
+			[][2]int{{0, 0}, {0, 10}}},
+		{"fill", 200, []*Block{fixed('l', 50), fill('l'), fixed('r', 30)},
+			[][2]int{{0, 50}, {50, 120}, {170, 30}}},
+		{"fill uneven", 201, []*Block{fixed('l', 50), fill('l'), fill('c')},
+			[][2]int{{0, 50}, {50, 75}, {125, 76}}},
+		{"center pushed left", 200, []*Block{fixed('l', 20),
+			fixed('c', 100), fixed('r', 80)}, [][2]int{{0, 20}, {20, 100},
+			{120, 80}}},
+		{"center pushed right", 200, []*Block{fixed('l', 90),
+			fixed('c', 60), fixed('r', 10)}, [][2]int{{0, 90}, {90, 60},
+			{190, 10}}},
+		{"overlap", 200, []*Block{fixed('l', 150), fill('c'),
+			fixed('r', 100)}, [][2]int{{0, 150}, {150, 0}, {100, 100}}},
+		{"overflow left", 200, []*Block{fixed('l', 250), fixed('l', 10)},
+			[][2]int{{0, 250}, {250, 10}}},
+		{"overflow right", 200, []*Block{fixed('r', 10), fixed('r', 250)},
+			[][2]int{{-60, 10}, {-50, 250}}},
+	} {
+		bar := testBar(tc.w, tc.bl...)
+		bar.layout()
+
+		for i, block := range tc.bl {
+			if block.x != tc.want[i][0] || block.w != tc.want[i][1] {
+				t.Errorf("layout(%s): Block %d at %d with a width of %d, "+
+					"want %d and %d", tc.name, i, block.x, block.w,
+					tc.want[i][0], tc.want[i][1])
+			}
+
+			// The image of the block is the part of the block inside the
+			// bar.
+			r := image.Rect(block.x, 0, block.x+block.w, bar.h).Intersect(
+				bar.img.Rect)
+			switch {
+			case r.Empty() && block.img != nil:
+				t.Errorf("layout(%s): Block %d has an image outside of the "+
+					"bar", tc.name, i)
+			case !r.Empty() && (block.img == nil || block.img.Rect != r):
+				t.Errorf("layout(%s): Block %d has the wrong image, want %v",
+					tc.name, i, r)
+			}
+		}
+	}
+}
+
+func TestFitWidth(t *testing.T) {
+	bar := testBar(200)
+
+	for _, tc := range []struct {
+		block *Block
+		want  int
+	}{
+		{&Block{}, 0},
+		{&Block{pad: 5}, 10},
+		{&Block{txt: "abc"}, 21},
+		{&Block{txt: "abc", pad: 3}, 27},
+		{&Block{txt: "a%{O10}b"}, 24},
+		{&Block{txt: "%{F#ff0000}abc%{F-}", pad: 1}, 23},
+		{&Block{txt: "ignored", icon: image.NewRGBA(image.Rect(0, 0, 16,
+			16)), pad: 2}, 20},
+		{&Block{tray: &tray{}, pad: 4}, 8},
+	} {
+		if w := bar.fitWidth(tc.block); w != tc.want {
+			t.Errorf("fitWidth(%q) = %d, want %d", tc.block.txt, w, tc.want)
+		}
+	}
+}
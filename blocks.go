@@ -1,349 +1,1522 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"image"
 	"log"
-	"os"
+	"math"
 	"os/exec"
-	"os/user"
-	"path"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/BurntSushi/xgb/xproto"
-	"github.com/BurntSushi/xgbutil"
 	"github.com/BurntSushi/xgbutil/ewmh"
-	"github.com/BurntSushi/xgbutil/icccm"
-	"github.com/BurntSushi/xgbutil/xevent"
 	"github.com/BurntSushi/xgbutil/xgraphics"
-	"github.com/BurntSushi/xgbutil/xprop"
-	"github.com/BurntSushi/xgbutil/xwindow"
+	"github.com/elliotchance/orderedmap"
 	"github.com/fhs/gompd/mpd"
 )
 
-func (bar *Bar) initBlocks() {
-	bar.blocks.Set("window-icon", &Block{
-		txt:   "ƀ",
-		w:     21,
-		align: 'r',
-		xoff:  3,
-		bg:    xgraphics.BGRA{B: 141, G: 191, R: 55, A: 0xFF},
-		fg:    xgraphics.BGRA{B: 255, G: 255, R: 255, A: 0xFF},
+// blockModules maps a module name to the function that sets up a block of
+// that type.
+var blockModules = map[string]func(*Bar, *Block, params) error{
+	"text":      (*Bar).textBlock,
+	"window":    (*Bar).windowBlock,
+	"icon":      (*Bar).iconBlock,
+	"taskbar":   (*Bar).taskbarBlock,
+	"tray":      (*Bar).trayBlock,
+	"notify":    (*Bar).notifyBlock,
+	"battery":   (*Bar).batteryBlock,
+	"cpu":       (*Bar).cpuBlock,
+	"memory":    (*Bar).memoryBlock,
+	"swap":      (*Bar).swapBlock,
+	"load":      (*Bar).loadBlock,
+	"network":   (*Bar).networkBlock,
+	"volume":    (*Bar).volumeBlock,
+	"disk":      (*Bar).diskBlock,
+	"sensors":   (*Bar).sensorsBlock,
+	"backlight": (*Bar).backlightBlock,
+	"keyboard":  (*Bar).keyboardBlock,
+	"workspace": (*Bar).workspaceBlock,
+	"clock":     (*Bar).clockBlock,
+	"music":     (*Bar).musicBlock,
+}
 
-		update: func() {},
-	})
+func (bar *Bar) initBlocks(cl []BlockConfig, m monitor) (*orderedmap.
+	OrderedMap, error) {
+	bm := orderedmap.NewOrderedMap()
+	for _, c := range cl {
+		if _, ok := bm.Get(c.Name); ok || c.Name == "" {
+			return nil, fmt.Errorf("block %q: Not a valid or unique name", c.Name)
+		}
 
-	bar.blocks.Set("window", &Block{
-		txt:   "?",
-		w:     200,
-		align: 'c',
-		xoff:  0,
-		bg:    xgraphics.BGRA{B: 141, G: 191, R: 55, A: 0xFF},
-		fg:    xgraphics.BGRA{B: 255, G: 255, R: 255, A: 0xFF},
+		// Skip blocks that shouldn't be on the monitor of this bar.
+		if m.name != "" && !m.match(c.Monitors) {
+			continue
+		}
 
-		update: func() {
-			block := bar.block("window")
+		block := &Block{
+			txt:  c.Txt,
+			size: c.W.size,
+			fw:   c.W.px,
+			pad:  c.Pad,
+			xoff: c.Xoff,
+		}
 
-			// Redraw block function.
-			t := func(id xproto.Window) {
-				// Set new block text.
-				txt, err := ewmh.WmNameGet(X, id)
-				if err != nil || len(txt) == 0 {
-					txt, err = icccm.WmNameGet(X, id)
-					if err != nil || len(txt) == 0 {
-						txt = "?"
-					}
+		// Parse the region, aligment and colors, these are optional for
+		// script blocks.
+		var err error
+		block.region = 'l'
+		if c.Region != "" {
+			if block.region, err = parseRegion(c.Region); err != nil {
+				return nil, fmt.Errorf("block %q: %v", c.Name, err)
+			}
+		}
+		block.align = 'l'
+		if c.Align != "" {
+			if block.align, err = parseAlign(c.Align); err != nil {
+				return nil, fmt.Errorf("block %q: %v", c.Name, err)
+			}
+		}
+		if c.Bg != "" {
+			if block.bg, err = parseColor(c.Bg); err != nil {
+				return nil, fmt.Errorf("block %q: %v", c.Name, err)
+			}
+		}
+		if c.Fg != "" {
+			if block.fg, err = parseColor(c.Fg); err != nil {
+				return nil, fmt.Errorf("block %q: %v", c.Name, err)
+			}
+		}
+
+		// Parse the scroll options.
+		if c.Scroll {
+			block.scroll = &marquee{speed: 30, pause: 2 * time.Second}
+			if c.Speed > 0 {
+				block.scroll.speed = c.Speed
+			}
+			if c.Pause != "" {
+				if block.scroll.pause, err = time.ParseDuration(
+					c.Pause); err != nil {
+					return nil, fmt.Errorf("block %q: %v", c.Name, err)
+				}
+			}
+		}
+
+		// Parse the clickable part and the actions.
+		switch c.Click {
+		case "", "block":
+		case "text":
+			block.clickText = true
+		default:
+			return nil, fmt.Errorf("block %q: Not a valid click %q", c.Name,
+				c.Click)
+		}
+		if block.actions, err = bar.parseActions(c.Actions); err != nil {
+			return nil, fmt.Errorf("block %q: %v", c.Name, err)
+		}
+
+		// Let the module set up the block.
+		m, ok := blockModules[c.Module]
+		if !ok {
+			return nil, fmt.Errorf("block %q: Unknown module %q", c.Name, c.Module)
+		}
+		if err := m(bar, block, c.Params); err != nil {
+			return nil, fmt.Errorf("block %q: %v", c.Name, err)
+		}
+
+		bm.Set(c.Name, block)
+	}
+
+	return bm, nil
+}
+
+// textBlock is a block that displays static text.
+func (bar *Bar) textBlock(block *Block, p params) error {
+	block.update = func(ctx context.Context) {}
+
+	return nil
+}
+
+// windowBlock is a block that displays the title of the active window. The
+// title is truncated to `width` pixels, or to the width of the block. Blocks
+// that fit their text use the width of the bar the other blocks leave free.
+// The block is tinted with the `urgent` color if the window demands
+// attention, and is empty if there is no active window.
+//
+// The `rules` parameter is a list of tables with a `class` regular expression
+// that is matched against the class and instance name of the window, and a
+// `match` regular expression in the title that is replaced with `replace`.
+func (bar *Bar) windowBlock(block *Block, p params) error {
+	w := p.int("width", 0)
+	ell, err := parseEllipsis(p.string("ellipsis", "end"))
+	if err != nil {
+		return err
+	}
+	bg := block.bg
+	urg := p.color("urgent", xgraphics.BGRA{B: 79, G: 96, R: 211, A: 0xFF})
+	rl, err := parseRules(p.tables("rules"))
+	if err != nil {
+		return err
+	}
+
+	block.update = func(ctx context.Context) {
+		// Redraw block function.
+		t := func(c client) {
+			// Clear the block if there is no active window.
+			if c.id == 0 {
+				block.mu.Lock()
+				same := block.txt == "" && block.bg == bg
+				block.txt = ""
+				block.bg = bg
+				block.mu.Unlock()
+				if !same {
+					bar.paint(block)
 				}
-				txt = trim(txt, 34)
+				return
+			}
 
-				// Return if the text is the same.
-				if txt == block.txt {
-					return
+			// Set new block text.
+			txt := c.name
+			for _, r := range rl {
+				if r.class.MatchString(c.class) || r.class.MatchString(
+					c.instance) {
+					txt = r.match.ReplaceAllString(txt, r.replace)
 				}
-				block.txt = txt
+			}
 
-				// Redraw block.
-				bar.redraw <- block
+			// Truncate the title, unless it scrolls.
+			if block.scroll == nil {
+				mw := w
+				if mw == 0 {
+					if block.size == 'f' {
+						mw = bar.freeWidth(block)
+					} else {
+						block.mu.Lock()
+						mw = block.w
+						block.mu.Unlock()
+					}
+					mw -= block.pad * 2
+				}
+				txt = truncate(bar.getFace(), txt, mw, ell)
 			}
+			txt = escapeMarkup(txt)
 
-			// Variable where we store the (previous) xwindow.
-			var xid *xwindow.Window
+			// Tint the block if the window demands attention.
+			bc := bg
+			if urgent(c.id) {
+				bc = urg
+			}
 
-			// Get window ID function.
-			f := func() {
-				// Get active window.
-				id, err := ewmh.ActiveWindowGet(X)
-				if err != nil {
-					log.Println(err)
-					return
+			// Return if the text and color are the same.
+			block.mu.Lock()
+			same := txt == block.txt && bc == block.bg
+			block.txt = txt
+			block.bg = bc
+			block.mu.Unlock()
+			if same {
+				return
+			}
+
+			// Redraw block.
+			bar.paint(block)
+		}
+
+		// Listen for active window and urgency changes, execute `t()`
+		// accordingly.
+		if err := watchActive(ctx, t, "_NET_WM_STATE", "WM_HINTS"); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return nil
+}
+
+// iconBlock is a block that displays the `_NET_WM_ICON` of the active window,
+// scaled to `size` pixels, or to the height of the bar minus `vpad` pixels
+// above and below the icon. The icon of the window closest to that size is
+// scaled. The text of the block is displayed instead if the window doesn't
+// have an icon.
+func (bar *Bar) iconBlock(block *Block, p params) error {
+	size := p.int("size", 0)
+	if size < 0 {
+		return fmt.Errorf("parse %d: Not a valid icon size", size)
+	}
+	vpad := p.int("vpad", 6)
+
+	block.update = func(ctx context.Context) {
+		// Fit the icon in the bar, the bar is resized when the config is
+		// reloaded which restarts this function.
+		size := size
+		if size == 0 {
+			bar.mu.RLock()
+			size = bar.h - (vpad * 2)
+			bar.mu.RUnlock()
+		}
+		if size <= 0 {
+			log.Printf("icon %d: Bar too small for the icon", size)
+			return
+		}
+
+		// Redraw block function.
+		t := func(c client) {
+			// Set new block icon.
+			var icon image.Image
+			if il, err := ewmh.WmIconGet(X, c.id); err == nil {
+				icon = scaleIcon(il, size)
+			}
+			block.mu.Lock()
+			same := icon == nil && block.icon == nil
+			block.icon = icon
+			block.mu.Unlock()
+			if same {
+				return
+			}
+
+			// Redraw block.
+			bar.paint(block)
+		}
+
+		// Listen for active window and icon changes, execute `t()`
+		// accordingly.
+		if err := watchActive(ctx, t, "_NET_WM_ICON"); err != nil {
+			log.Println(err)
+		}
+	}
+
+	return nil
+}
+
+// taskbarBlock is a block that displays an item for every window on the
+// current desktop, with the icon and title of the window. The active window is
+// highlighted, and minimized windows are dimmed and put between brackets.
+// Clicking an item activates the window, and middle clicking closes it.
+func (bar *Bar) taskbarBlock(block *Block, p params) error {
+	size := p.int("size", 16)
+	act := p.color("active", xgraphics.BGRA{B: 211, G: 167, R: 114, A: 0xFF})
+	hid := p.color("minimized", xgraphics.BGRA{B: 228, G: 201, R: 169,
+		A: 0xFF})
+	fg := block.fg
+	bg := block.bg
+
+	block.update = func(ctx context.Context) {
+		// The redraw function is called from both the X event loop and the
+		// update function, and caches the icons of the windows.
+		var mu sync.Mutex
+		icons := make(map[xproto.Window]image.Image)
+
+		// Redraw block function.
+		t := func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			wl, err := desktopWindows()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			aw, _ := ewmh.ActiveWindowGet(X)
+
+			// Create an item for every window.
+			il := []item{}
+			for _, id := range wl {
+				it := item{
+					txt: windowName(id),
+					fg:  fg,
+					bg:  bg,
+					actions: map[xproto.Button]string{
+						1: "window " + strconv.Itoa(int(id)),
+						2: "close " + strconv.Itoa(int(id)),
+						4: "window prev",
+						5: "window next",
+					},
+				}
+				if id == aw {
+					it.bg = act
 				}
-				if id == 0 {
-					return
+				if hidden(id) {
+					it.txt = "[" + it.txt + "]"
+					it.fg = hid
 				}
 
-				// Stop listening to the previous window.
-				if xid != nil {
-					xid.Detach()
+				// Get the icon of the window.
+				icon, ok := icons[id]
+				if !ok {
+					if l, err := ewmh.WmIconGet(X, id); err == nil {
+						icon = scaleIcon(l, size)
+					}
+					icons[id] = icon
 				}
+				it.icon = icon
 
-				// Create xwindow from active window.
-				xid = xwindow.New(X, id)
+				il = append(il, it)
+			}
+			block.mu.Lock()
+			block.items = il
+			block.mu.Unlock()
 
-				// Listen to this window for window name changes.
-				xid.Listen(xproto.EventMaskPropertyChange)
-				xevent.PropertyNotifyFun(func(_ *xgbutil.XUtil, ev xevent.
-					PropertyNotifyEvent) {
-					// Only listen to `_NET_WM_NAME` events.
-					atom, err := xprop.Atm(X, "_NET_WM_NAME")
-					if err != nil {
-						log.Println(err)
-						return
-					}
-					if ev.Atom != atom {
-						return
-					}
+			// Redraw block.
+			bar.paint(block)
+		}
+
+		// Function that stops listening to the previous clients, this is
+		// guarded by `mu`.
+		cancel := func() {}
 
-					t(id)
-				}).Connect(X, id)
+		// Client list change function.
+		f := func() {
+			// Stop listening to the previous clients, and forget their
+			// icons.
+			mu.Lock()
+			cancel()
+			var cctx context.Context
+			cctx, cancel = context.WithCancel(ctx)
+			icons = make(map[xproto.Window]image.Image)
+			mu.Unlock()
 
-				t(id)
+			// Listen to the clients for changes, the window might be gone
+			// already so errors are ignored.
+			cl, _ := ewmh.ClientListGet(X)
+			for _, id := range cl {
+				id := id
+				watch(cctx, id, t, "_NET_WM_DESKTOP", "_NET_WM_STATE",
+					"_NET_WM_NAME", "WM_NAME")
+				watch(cctx, id, func() {
+					mu.Lock()
+					delete(icons, id)
+					mu.Unlock()
+					t()
+				}, "_NET_WM_ICON")
 			}
 
-			// Listen for window change event, execute `f()` accordingly.
-			xevent.PropertyNotifyFun(func(_ *xgbutil.XUtil, ev xevent.
-				PropertyNotifyEvent) {
-				// Only listen to `_NET_ACTIVE_WINDOW` events.
-				atom, err := xprop.Atm(X, "_NET_ACTIVE_WINDOW")
-				if err != nil {
+			t()
+		}
+
+		// Listen for desktop, active window and client list change events,
+		// execute `t()` and `f()` accordingly.
+		if err := watch(ctx, X.RootWin(), t, "_NET_CURRENT_DESKTOP",
+			"_NET_ACTIVE_WINDOW"); err != nil {
+			log.Println(err)
+			return
+		}
+		if err := watch(ctx, X.RootWin(), f, "_NET_CLIENT_LIST"); err != nil {
+			log.Println(err)
+			return
+		}
+
+		// Execute `f()` one time initially.
+		f()
+	}
+
+	return nil
+}
+
+// trayBlock is a block that hosts an XEmbed system tray. The icons are
+// resized to `size` pixels, or to the height of the bar, with `gap` pixels
+// between them. Only one tray can exist at a time, a tray block waits until
+// the previous tray is gone.
+func (bar *Bar) trayBlock(block *Block, p params) error {
+	block.tray = &tray{
+		size: p.int("size", 0),
+		gap:  p.int("gap", 4),
+	}
+
+	block.update = func(ctx context.Context) {
+		// Redraw block, this reflows the bar if the width of the tray
+		// changed.
+		f := func() {
+			bar.paint(block)
+		}
+
+		if err := block.tray.run(ctx, bar, f); err != nil {
+			log.Println(err)
+		}
+
+		// Redraw the block without icons.
+		f()
+	}
+
+	return nil
+}
+
+// notifyBlock is a block that runs a notification daemon, and displays the
+// summary of the newest notification until it expires. The background is
+// colored using the `low`, `normal` and `critical` colors depending on the
+// urgency, and notifications that don't specify a timeout expire after
+// `timeout`. The `popup` parameter is the name of the popup that should be
+// updated alongside it.
+func (bar *Bar) notifyBlock(block *Block, p params) error {
+	ul := []xgraphics.BGRA{
+		p.color("low", block.bg),
+		p.color("normal", block.bg),
+		p.color("critical", xgraphics.BGRA{B: 79, G: 96, R: 211, A: 0xFF}),
+	}
+	d := p.duration("timeout", 5*time.Second)
+	pk := p.string("popup", "notify")
+	pre := block.txt
+	bg := block.bg
+
+	block.update = func(ctx context.Context) {
+		// Start the notification daemon.
+		n, err := notifications()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		n.mu.Lock()
+		n.timeout = d
+		n.mu.Unlock()
+
+		// Watch the daemon for changes.
+		c := n.subscribe(ctx)
+		for {
+			// Set new block text and color, using the newest open
+			// notification.
+			txt, bc := pre, bg
+			for _, no := range n.list() {
+				if no.closed {
+					continue
+				}
+				txt = escapeMarkup(no.summary)
+				if int(no.urgency) < len(ul) {
+					bc = ul[no.urgency]
+				}
+				break
+			}
+			block.mu.Lock()
+			block.txt = txt
+			block.bg = bc
+			block.mu.Unlock()
+
+			// Redraw block.
+			bar.paint(block)
+
+			// Update popup if open.
+			if popup := bar.popup(pk); popup != nil && popup.open {
+				popup.update()
+			}
+
+			// Wait for next change.
+			select {
+			case <-ctx.Done():
+				return
+			case <-c:
+			}
+		}
+	}
+
+	return nil
+}
+
+// batteryBlock is a block that displays the capacity, status and remaining
+// time of the batteries in `root`, or of the batteries listed in `batteries`.
+// The block is colored using `low-bg` and `critical-bg` once the capacity
+// drops below `low` and `critical` percent while discharging. Below `critical`
+// percent the block flashes every `flash` interval if set, and the `notify`
+// command is executed once.
+func (bar *Bar) batteryBlock(block *Block, p params) error {
+	root := p.string("root", "/sys")
+	bl := p.strings("batteries")
+	low := p.int("low", 20)
+	crit := p.int("critical", 10)
+	lbg := p.color("low-bg", xgraphics.BGRA{B: 60, G: 170, R: 220, A: 0xFF})
+	cbg := p.color("critical-bg", xgraphics.BGRA{B: 79, G: 96, R: 211,
+		A: 0xFF})
+	flash := p.duration("flash", 0)
+	cmd := p.string("notify", "")
+	d := p.duration("interval", time.Minute)
+	pre := block.txt
+	bg := block.bg
+
+	block.update = func(ctx context.Context) {
+		// Watch for power supply uevents, these are sent when the charger is
+		// plugged in for example. Not every battery sends uevents when its
+		// capacity changes, so we also poll every interval.
+		c := watchUevents(ctx, "power_supply")
+
+		notified := false
+		lit := false
+		for {
+			b, err := readBatteries(root, bl)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			// Set new block text.
+			var s string
+			if b.status == "Charging" {
+				s = "+"
+			}
+			s += strconv.Itoa(b.capacity) + "%"
+			if b.remaining > 0 {
+				m := int(b.remaining.Minutes())
+				s += fmt.Sprintf(" %d:%02d", m/60, m%60)
+			}
+
+			// Set new block color, and flash the block if required.
+			dis := b.status == "Discharging"
+			bc := bg
+			wait := d
+			switch {
+			case dis && b.capacity <= crit:
+				bc = cbg
+				if flash > 0 {
+					lit = !lit
+					if !lit {
+						bc = bg
+					}
+					wait = flash
+				}
+			case dis && b.capacity <= low:
+				bc = lbg
+			}
+			block.mu.Lock()
+			block.txt = pre + s
+			block.bg = bc
+			block.mu.Unlock()
+
+			// Execute the notify command once per discharge.
+			if dis && b.capacity <= crit && !notified && cmd != "" {
+				notified = true
+				if err := exec.Command("sh", "-c", cmd).Start(); err != nil {
 					log.Println(err)
-					return
 				}
-				if ev.Atom != atom {
-					return
+			}
+			if !dis {
+				notified = false
+			}
+
+			// Redraw block.
+			bar.paint(block)
+
+			// Update on the next uevent or interval.
+			select {
+			case <-ctx.Done():
+				return
+			case <-c:
+			case <-time.After(wait):
+			}
+		}
+	}
+
+	return nil
+}
+
+// sampleBlock sets up a block that executes `f` every `interval`. The function
+// returns the text to display, a level that is compared against the `high`
+// and `critical` thresholds to color the block using `high-bg` and
+// `critical-bg`, and a value between zero and one for the graph. The `graph`
+// parameter decides how the block is displayed, this can be `text`,
+// `sparkline` or `bar`.
+func (bar *Bar) sampleBlock(block *Block, p params, high, crit float64,
+	f func() (string, float64, float64, error)) error {
+	d := p.duration("interval", 2*time.Second)
+	high = p.float("high", high)
+	crit = p.float("critical", crit)
+	hbg := p.color("high-bg", xgraphics.BGRA{B: 60, G: 170, R: 220, A: 0xFF})
+	cbg := p.color("critical-bg", xgraphics.BGRA{B: 79, G: 96, R: 211,
+		A: 0xFF})
+	pre := block.txt
+	bg := block.bg
+
+	switch p.string("graph", "text") {
+	case "text":
+	case "sparkline":
+		block.graph = &graph{mode: 's'}
+	case "bar":
+		block.graph = &graph{mode: 'b'}
+	default:
+		return fmt.Errorf("parse %q: Not a valid graph", p.string("graph",
+			""))
+	}
+
+	block.update = func(ctx context.Context) {
+		for {
+			txt, lvl, v, err := f()
+			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			// Set new block text, graph and color.
+			block.mu.Lock()
+			block.txt = pre + txt
+			if block.graph != nil {
+				vl := append(block.graph.values, v)
+				if len(vl) > block.w {
+					vl = vl[len(vl)-block.w:]
 				}
+				block.graph = &graph{mode: block.graph.mode, values: vl}
+			}
+			switch {
+			case lvl >= crit:
+				block.bg = cbg
+			case lvl >= high:
+				block.bg = hbg
+			default:
+				block.bg = bg
+			}
+			block.mu.Unlock()
+
+			// Redraw block.
+			bar.paint(block)
+
+			// Update every interval.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+		}
+	}
+
+	return nil
+}
+
+// cpuBlock is a block that displays the CPU usage in percent, read from
+// `stat` in `root`. The `popup` parameter is the name of the popup that
+// displays the usage history of each core, and should be updated alongside
+// it.
+func (bar *Bar) cpuBlock(block *Block, p params) error {
+	root := p.string("root", "/proc")
+	pk := p.string("popup", "cpu")
 
-				f()
-			}).Connect(X, X.RootWin())
+	// Store the usage history of each core for the popup.
+	h := &history{max: 200}
+	bar.setStore("cpu", h)
 
-			// Execute `f()` one time initially.
-			f()
-		},
+	var prev []cpuTimes
+	return bar.sampleBlock(block, p, 70, 90, func() (string, float64, float64,
+		error) {
+		tl, err := readCPU(root)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		if len(prev) != len(tl) {
+			prev = make([]cpuTimes, len(tl))
+		}
+
+		// Calculate the usage since the previous sample.
+		ul := make([]float64, len(tl))
+		for i, t := range tl {
+			ul[i] = t.usage(prev[i])
+		}
+		prev = tl
+		h.add(ul[1:]...)
+
+		// Update popup if open.
+		if popup := bar.popup(pk); popup != nil && popup.open {
+			popup.update()
+		}
+
+		pct := ul[0] * 100
+		return fmt.Sprintf("%.0f%%", pct), pct, ul[0], nil
 	})
+}
+
+// memoryBlock is a block that displays the used memory, read from `meminfo`
+// in `root`.
+func (bar *Bar) memoryBlock(block *Block, p params) error {
+	root := p.string("root", "/proc")
 
-	bar.blocks.Set("workspace-www", &Block{
-		txt:   "Ɓ   www",
-		w:     74,
-		align: 'l',
-		xoff:  12,
-		bg:    xgraphics.BGRA{B: 201, G: 148, R: 83, A: 0xFF},
-		fg:    xgraphics.BGRA{B: 255, G: 255, R: 255, A: 0xFF},
-
-		update: func() {},
-
-		actions: map[xproto.Button]func() error{
-			1: func() error {
-				return ewmh.CurrentDesktopReq(X, 0)
-			},
-			4: func() error {
-				return ewmh.CurrentDesktopReq(X, bar.store["prev"].(int))
-			},
-			5: func() error {
-				return ewmh.CurrentDesktopReq(X, bar.store["next"].(int))
-			},
-		},
+	return bar.sampleBlock(block, p, 70, 90, func() (string, float64, float64,
+		error) {
+		m, err := readMeminfo(root)
+		if err != nil {
+			return "", 0, 0, err
+		}
+		if m["MemTotal"] == 0 {
+			return "", 0, 0, fmt.Errorf("memory %q: No memory found", root)
+		}
+
+		used := m["MemTotal"] - m["MemAvailable"]
+		v := float64(used) / float64(m["MemTotal"])
+		return formatKB(used), v * 100, v, nil
 	})
+}
+
+// swapBlock is a block that displays the used swap, read from `meminfo` in
+// `root`.
+func (bar *Bar) swapBlock(block *Block, p params) error {
+	root := p.string("root", "/proc")
+
+	return bar.sampleBlock(block, p, 50, 80, func() (string, float64, float64,
+		error) {
+		m, err := readMeminfo(root)
+		if err != nil {
+			return "", 0, 0, err
+		}
 
-	bar.blocks.Set("workspace-irc", &Block{
-		txt:   "Ƃ   irc",
-		w:     67,
-		align: 'l',
-		xoff:  12,
-		bg:    xgraphics.BGRA{B: 201, G: 148, R: 83, A: 0xFF},
-		fg:    xgraphics.BGRA{B: 255, G: 255, R: 255, A: 0xFF},
-
-		update: func() {},
-
-		actions: map[xproto.Button]func() error{
-			1: func() error {
-				return ewmh.CurrentDesktopReq(X, 1)
-			},
-			4: func() error {
-				return ewmh.CurrentDesktopReq(X, bar.store["prev"].(int))
-			},
-			5: func() error {
-				return ewmh.CurrentDesktopReq(X, bar.store["next"].(int))
-			},
-		},
+		used := m["SwapTotal"] - m["SwapFree"]
+		var v float64
+		if m["SwapTotal"] > 0 {
+			v = float64(used) / float64(m["SwapTotal"])
+		}
+		return formatKB(used), v * 100, v, nil
 	})
+}
+
+// loadBlock is a block that displays the 1, 5 and 15 minute load averages,
+// read from `loadavg` in `root`. The thresholds are compared against the 1
+// minute load average, and the graph displays it relative to the number of
+// cores.
+func (bar *Bar) loadBlock(block *Block, p params) error {
+	root := p.string("root", "/proc")
+	n := float64(goruntime.NumCPU())
+
+	return bar.sampleBlock(block, p, n, n*2, func() (string, float64, float64,
+		error) {
+		l, err := readLoad(root)
+		if err != nil {
+			return "", 0, 0, err
+		}
 
-	bar.blocks.Set("workspace-src", &Block{
-		txt:   "ƃ   src",
-		w:     70,
-		align: 'l',
-		xoff:  12,
-		bg:    xgraphics.BGRA{B: 201, G: 148, R: 83, A: 0xFF},
-		fg:    xgraphics.BGRA{B: 255, G: 255, R: 255, A: 0xFF},
-
-		update: func() {},
-
-		actions: map[xproto.Button]func() error{
-			1: func() error {
-				return ewmh.CurrentDesktopReq(X, 2)
-			},
-			4: func() error {
-				return ewmh.CurrentDesktopReq(X, bar.store["prev"].(int))
-			},
-			5: func() error {
-				return ewmh.CurrentDesktopReq(X, bar.store["next"].(int))
-			},
-		},
+		return fmt.Sprintf("%.2f %.2f %.2f", l[0], l[1], l[2]), l[0], l[0] / n,
+			nil
 	})
+}
 
-	bar.blocks.Set("workspace", &Block{
-		script: true,
+// networkBlock is a block that displays the name, SSID, signal strength,
+// address and receive and transmit rates of `interface`, or of the interface
+// of the default route. The block is colored using `down-bg` when the link is
+// down. Sysfs is read below `sys` and procfs below `proc`. The `popup`
+// parameter is the name of the popup that displays all interfaces, and
+// should be updated alongside it.
+func (bar *Bar) networkBlock(block *Block, p params) error {
+	sys := p.string("sys", "/sys")
+	proc := p.string("proc", "/proc")
+	name := p.string("interface", "")
+	dbg := p.color("down-bg", xgraphics.BGRA{B: 79, G: 96, R: 211, A: 0xFF})
+	d := p.duration("interval", 2*time.Second)
+	pk := p.string("popup", "network")
+	pre := block.txt
+	bg := block.bg
 
-		update: func() {
-			www := bar.block("workspace-www")
-			irc := bar.block("workspace-irc")
-			src := bar.block("workspace-src")
+	// Store the rates of every interface for the popup.
+	ns := &netStats{lookup: hostLookup, history: make(map[string]*history)}
+	bar.setStore("network", ns)
 
-			f := func() {
-				// Get the current active desktop.
-				wsp, err := ewmh.CurrentDesktopGet(X)
-				if err != nil {
+	block.update = func(ctx context.Context) {
+		for {
+			il, err := ns.sample(sys, proc)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			_, rates, _ := ns.get()
+
+			// Find the interface to display.
+			n := name
+			if n == "" {
+				n = defaultIface(proc)
+			}
+			var iface *netIface
+			for i := range il {
+				if il[i].name == n {
+					iface = &il[i]
+				}
+			}
+
+			// Set new block text and color.
+			var txt string
+			bc := bg
+			switch {
+			case iface == nil:
+				txt = "offline"
+				bc = dbg
+			case !iface.up:
+				txt = escapeMarkup(iface.name) + " down"
+				bc = dbg
+			default:
+				txt = escapeMarkup(iface.name)
+				if iface.ssid != "" {
+					txt += " " + escapeMarkup(iface.ssid) + " " + strconv.
+						Itoa(iface.signal) + "%"
+				}
+				for _, a := range iface.addrs {
+					if !strings.Contains(a, ":") {
+						txt += " " + a
+						break
+					}
+				}
+				r := rates[iface.name]
+				txt += " " + formatRate(r[0]) + "/" + formatRate(r[1])
+			}
+			block.mu.Lock()
+			block.txt = pre + txt
+			block.bg = bc
+			block.mu.Unlock()
+
+			// Redraw block.
+			bar.paint(block)
+
+			// Update popup if open.
+			if popup := bar.popup(pk); popup != nil && popup.open {
+				popup.update()
+			}
+
+			// Update every interval.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+		}
+	}
+
+	return nil
+}
+
+// volumeBlock is a block that displays the volume of the default sink, using
+// the PulseAudio or PipeWire server `server`, or the ALSA control `control`.
+// The `backend` can be `pulse`, `alsa` or `auto`, and the block connects again
+// when the connection is lost. The block is colored using `muted-bg` and
+// `muted-fg` when the sink is muted. The `volume` actions change the volume by
+// `step` percent up to `max` percent. The `popup` parameter is the name of the
+// popup that displays all sinks and streams, and should be updated alongside
+// it.
+func (bar *Bar) volumeBlock(block *Block, p params) error {
+	backend := p.string("backend", "auto")
+	server := p.string("server", "")
+	control := p.string("control", "Master")
+	mbg := p.color("muted-bg", block.bg)
+	mfg := p.color("muted-fg", xgraphics.BGRA{B: 128, G: 118, R: 112,
+		A: 0xFF})
+	pk := p.string("popup", "volume")
+	pre := block.txt
+	bg := block.bg
+	fg := block.fg
+
+	// Store the volume control for the actions and popup.
+	vc := &volumeControl{
+		step: p.float("step", 5) / 100,
+		max:  p.float("max", 100) / 100,
+	}
+	bar.setStore("volume", vc)
+
+	block.update = func(ctx context.Context) {
+		vc.run(ctx, func() (mixer, error) {
+			return connectMixer(ctx, backend, server, control)
+		}, time.Second, func(cl []mixerChannel, err error) {
+			if err != nil {
+				log.Println(err)
+			}
+
+			// Set new block text and colors.
+			block.mu.Lock()
+			block.bg, block.fg = bg, fg
+			c, ok := defaultSink(cl)
+			switch {
+			case err != nil:
+				block.txt = pre + "no mixer"
+			case !ok:
+				block.txt = pre + "no sink"
+			case c.mute:
+				block.txt = pre + "muted"
+				block.bg, block.fg = mbg, mfg
+			default:
+				block.txt = pre + strconv.Itoa(int(math.Round(c.volume*
+					100))) + "%"
+			}
+			block.mu.Unlock()
+
+			// Redraw block.
+			bar.paint(block)
+
+			// Update popup if open.
+			if popup := bar.popup(pk); popup != nil && popup.open {
+				popup.update()
+			}
+		})
+	}
+
+	return nil
+}
+
+// diskBlock is a block that displays the `free` or `used` space, depending on
+// `show`, of the file systems mounted on `mounts`, and of removable devices if
+// `removable` is set. The block is colored using `high-bg` and `critical-bg`
+// once a file system is more than `high` or `critical` percent full. Mounts
+// are read from `mountinfo` and sysfs below `sys`, and are read again on
+// every mount or unmount and every `interval`. Removable devices are
+// unmounted using the `unmount` command, in which `%d` is replaced by the
+// device and `%m` by the mount point. The `popup` parameter is the name of
+// the popup that displays the mounts, and should be updated alongside it.
+func (bar *Bar) diskBlock(block *Block, p params) error {
+	dl := p.strings("mounts")
+	if len(dl) == 0 {
+		dl = []string{"/"}
+	}
+	show := p.string("show", "free")
+	if show != "free" && show != "used" {
+		return fmt.Errorf("parse %q: Not a valid show", show)
+	}
+	rem := p.bool("removable", true)
+	high := p.float("high", 80) / 100
+	crit := p.float("critical", 90) / 100
+	hbg := p.color("high-bg", xgraphics.BGRA{B: 60, G: 170, R: 220, A: 0xFF})
+	cbg := p.color("critical-bg", xgraphics.BGRA{B: 79, G: 96, R: 211,
+		A: 0xFF})
+	mi := p.string("mountinfo", "/proc/self/mountinfo")
+	sys := p.string("sys", "/sys")
+	d := p.duration("interval", time.Minute)
+	pk := p.string("popup", "disk")
+	pre := block.txt
+	bg := block.bg
+
+	// Store the mounts for the popup.
+	ds := &diskStats{unmount: p.string("unmount", "udisksctl unmount -b %d")}
+	bar.setStore("disk", ds)
+
+	block.update = func(ctx context.Context) {
+		w := watchMounts(ctx, mi)
+		for {
+			all, err := readMounts(mi, sys)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			// Pick the configured mounts, in the configured order, followed
+			// by the removable devices.
+			var ml []mount
+			for _, dir := range dl {
+				for i := len(all) - 1; i >= 0; i-- {
+					if all[i].dir == dir {
+						ml = append(ml, all[i])
+						break
+					}
+				}
+			}
+			for _, m := range all {
+				if rem && m.removable && !contains(dl, m.dir) {
+					ml = append(ml, m)
+				}
+			}
+
+			// Read the usage, and set new block text and color.
+			var tl []string
+			var max float64
+			for i := range ml {
+				if err := ml[i].usage(); err != nil {
 					log.Println(err)
-					return
+					continue
+				}
+				v := ml[i].used
+				if show == "free" {
+					v = ml[i].avail
 				}
+				tl = append(tl, escapeMarkup(ml[i].dir)+" "+formatKB(v/
+					1024))
+				max = math.Max(max, ml[i].fraction())
+			}
+			ds.set(ml)
+			block.mu.Lock()
+			block.txt = pre + strings.Join(tl, " ")
+			switch {
+			case max >= crit:
+				block.bg = cbg
+			case max >= high:
+				block.bg = hbg
+			default:
+				block.bg = bg
+			}
+			block.mu.Unlock()
+
+			// Redraw block.
+			bar.paint(block)
+
+			// Update popup if open.
+			if popup := bar.popup(pk); popup != nil && popup.open {
+				popup.update()
+			}
 
-				// Set new block colors and the previous/next workspaces.
-				switch wsp {
-				case 0:
-					www.bg = xgraphics.BGRA{B: 211, G: 167, R: 114, A: 0xFF}
-					irc.bg = xgraphics.BGRA{B: 201, G: 148, R: 83, A: 0xFF}
-					src.bg = xgraphics.BGRA{B: 201, G: 148, R: 83, A: 0xFF}
+			// Update on every mount and every interval.
+			select {
+			case <-ctx.Done():
+				return
+			case <-w:
+			case <-time.After(d):
+			}
+		}
+	}
 
-					bar.store["prev"] = 2
-					bar.store["next"] = 1
-				case 1:
-					www.bg = xgraphics.BGRA{B: 201, G: 148, R: 83, A: 0xFF}
-					irc.bg = xgraphics.BGRA{B: 211, G: 167, R: 114, A: 0xFF}
-					src.bg = xgraphics.BGRA{B: 201, G: 148, R: 83, A: 0xFF}
+	return nil
+}
 
-					bar.store["prev"] = 0
-					bar.store["next"] = 2
-				case 2:
-					www.bg = xgraphics.BGRA{B: 201, G: 148, R: 83, A: 0xFF}
-					irc.bg = xgraphics.BGRA{B: 201, G: 148, R: 83, A: 0xFF}
-					src.bg = xgraphics.BGRA{B: 211, G: 167, R: 114, A: 0xFF}
+// sensorsBlock is a block that displays the hottest temperature of the hwmon
+// sensors in sysfs below `root` that match one of the `sensors` patterns, and
+// the speed of the fastest fan if `fans` is set. The block is colored using
+// `high-bg` above `high` degrees, and using `critical-bg` above `critical`
+// degrees or the critical temperature of the sensor. The `popup` parameter
+// is the name of the popup that displays the history of every sensor, and
+// should be updated alongside it.
+func (bar *Bar) sensorsBlock(block *Block, p params) error {
+	root := p.string("root", "/sys")
+	pl := p.strings("sensors")
+	if len(pl) == 0 {
+		pl = []string{"*"}
+	}
+	fans := p.bool("fans", false)
+	high := p.float("high", 70)
+	crit := p.float("critical", 0)
+	hbg := p.color("high-bg", xgraphics.BGRA{B: 60, G: 170, R: 220, A: 0xFF})
+	cbg := p.color("critical-bg", xgraphics.BGRA{B: 79, G: 96, R: 211,
+		A: 0xFF})
+	d := p.duration("interval", 5*time.Second)
+	pk := p.string("popup", "sensors")
+	pre := block.txt
+	bg := block.bg
 
-					bar.store["prev"] = 1
-					bar.store["next"] = 0
+	// Store the sensors for the popup.
+	ss := &sensorStats{history: make(map[string]*history)}
+	bar.setStore("sensors", ss)
+
+	block.update = func(ctx context.Context) {
+		for {
+			all, err := readSensors(root)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+
+			// Pick the matching sensors, and find the hottest temperature
+			// and fastest fan.
+			var sl []sensor
+			var t, f *sensor
+			for _, s := range all {
+				if !s.match(pl) || s.kind == 'f' && !fans {
+					continue
 				}
+				sl = append(sl, s)
 
-				// Redraw block.
-				bar.redraw <- www
-				bar.redraw <- irc
-				bar.redraw <- src
+				s := s
+				switch {
+				case s.kind == 't' && (t == nil || s.value > t.value):
+					t = &s
+				case s.kind == 'f' && (f == nil || s.value > f.value):
+					f = &s
+				}
 			}
+			ss.add(sl)
 
-			// Listen for workspace change event, execute `f()` accordingly.
-			xevent.PropertyNotifyFun(func(_ *xgbutil.XUtil, ev xevent.
-				PropertyNotifyEvent) {
-				// Only listen to `_NET_ACTIVE_WINDOW` events.
-				atom, err := xprop.Atm(X, "_NET_CURRENT_DESKTOP")
-				if err != nil {
-					log.Println(err)
-					return
+			// Set new block text and color.
+			var tl []string
+			bc := bg
+			if t != nil {
+				tl = append(tl, strconv.Itoa(int(math.Round(t.value)))+"°C")
+
+				c := crit
+				if c == 0 {
+					c = t.crit
 				}
-				if ev.Atom != atom {
-					return
+				switch {
+				case c > 0 && t.value >= c:
+					bc = cbg
+				case t.value >= high:
+					bc = hbg
 				}
+			}
+			if f != nil {
+				tl = append(tl, strconv.Itoa(int(f.value))+" RPM")
+			}
+			if len(tl) == 0 {
+				tl = append(tl, "no sensors")
+			}
+			block.mu.Lock()
+			block.txt = pre + strings.Join(tl, " ")
+			block.bg = bc
+			block.mu.Unlock()
 
-				f()
-			}).Connect(X, X.RootWin())
+			// Redraw block.
+			bar.paint(block)
 
-			// Execute `f()` one time initially.
-			f()
-		},
-	})
+			// Update popup if open.
+			if popup := bar.popup(pk); popup != nil && popup.open {
+				popup.update()
+			}
 
-	bar.blocks.Set("clock", &Block{
-		txt:   "?",
-		w:     799,
-		align: 'a',
-		xoff:  0,
-		bg:    xgraphics.BGRA{B: 103, G: 89, R: 68, A: 0xFF},
-		fg:    xgraphics.BGRA{B: 204, G: 204, R: 204, A: 0xFF},
+			// Update every interval.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+		}
+	}
 
-		update: func() {
-			for {
-				block := bar.block("clock")
+	return nil
+}
 
-				// Set new block text.
-				block.txt = time.Now().Format("Monday, January 2th 03:04 PM")
+// backlightBlock is a block that displays the brightness of the backlight
+// `device` in sysfs below `root`, or of the backlight property of the RandR
+// `output`, depending on `source`. The `backlight` actions change the
+// brightness by `step` percent, down to `min` percent. If sysfs isn't
+// writable the brightness is set using the `helper` command, in which `%v` is
+// replaced by the raw brightness and `%p` by the percentage.
+func (bar *Bar) backlightBlock(block *Block, p params) error {
+	source := p.string("source", "auto")
+	root := p.string("root", "/sys")
+	device := p.string("device", "")
+	output := p.string("output", "")
+	helper := p.string("helper", "")
+	pre := block.txt
 
-				// Redraw block.
-				bar.redraw <- block
+	// Store the backlight control for the actions.
+	bc := &backlightControl{
+		step: p.float("step", 5) / 100,
+		min:  p.float("min", 1) / 100,
+	}
+	bar.setStore("backlight", bc)
+
+	block.update = func(ctx context.Context) {
+		// Open the backlight.
+		b, err := openBacklight(source, root, device, output, helper)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		bc.Lock()
+		bc.b = b
+		bc.Unlock()
 
-				// Update every 45 seconds.
-				time.Sleep(45 * time.Second)
+		c := b.changes(ctx)
+		for {
+			v, err := b.get()
+			if err != nil {
+				log.Println(err)
+				return
 			}
-		},
 
-		actions: map[xproto.Button]func() error{
-			1: func() error {
-				return bar.drawPopup("clock")
-			},
-		},
-	})
+			// Set new block text.
+			block.mu.Lock()
+			block.txt = pre + percent(v)
+			block.mu.Unlock()
+
+			// Redraw block.
+			bar.paint(block)
+
+			// Wait for the brightness to change.
+			select {
+			case <-ctx.Done():
+				return
+			case <-c:
+			}
+		}
+	}
+
+	return nil
+}
+
+// keyboardBlock is a block that displays the layout of the current keyboard
+// group, for example `us`, and the state of Caps Lock and Num Lock if `locks`
+// is set. The `keyboard` actions switch between the groups.
+func (bar *Bar) keyboardBlock(block *Block, p params) error {
+	locks := p.bool("locks", false)
+	pre := block.txt
+
+	block.update = func(ctx context.Context) {
+		st, err := getXKBState()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		// Watch for state changes, and for changes of the layouts.
+		c := make(chan xkbState, 1)
+		if err := watchXKB(ctx, func(st xkbState) {
+			select {
+			case <-c:
+			default:
+			}
+			c <- st
+		}); err != nil {
+			log.Println(err)
+			return
+		}
+		lc := make(chan struct{}, 1)
+		if err := watch(ctx, X.RootWin(), func() {
+			select {
+			case lc <- struct{}{}:
+			default:
+			}
+		}, "_XKB_RULES_NAMES"); err != nil {
+			log.Println(err)
+			return
+		}
 
-	bar.blocks.Set("music", &Block{
-		txt:   " Ƅ  ",
-		w:     660,
-		align: 'r',
-		xoff:  -12,
-		bg:    xgraphics.BGRA{B: 91, G: 79, R: 60, A: 0xFF},
-		fg:    xgraphics.BGRA{B: 204, G: 204, R: 204, A: 0xFF},
-
-		update: func() {
-			block := bar.block("music")
-			popup := bar.popup("music")
-
-			// Connect to MPD.
-			var err error
-			bar.store["mpd"], err = mpd.Dial("tcp", ":6600")
+		ll := xkbLayouts()
+		for {
+			// Set new block text.
+			txt := "group " + strconv.Itoa(int(st.group)+1)
+			if int(st.group) < len(ll) {
+				txt = ll[st.group]
+			}
+			if locks && st.lockedMods&xkbCapsLock != 0 {
+				txt += " caps"
+			}
+			if locks && st.lockedMods&xkbNumLock != 0 {
+				txt += " num"
+			}
+			block.mu.Lock()
+			block.txt = pre + txt
+			block.mu.Unlock()
+
+			// Redraw block.
+			bar.paint(block)
+
+			select {
+			case <-ctx.Done():
+				return
+			case st = <-c:
+			case <-lc:
+				ll = xkbLayouts()
+			}
+		}
+	}
+
+	return nil
+}
+
+// workspaceBlock is a block that displays a button for every desktop, as
+// read from `_NET_NUMBER_OF_DESKTOPS` and `_NET_DESKTOP_NAMES`. The current
+// desktop is highlighted, and desktops without any windows are dimmed. The
+// `icons` parameter lists an icon to put before the name of each desktop.
+//
+// Desktops with a window that demands attention are tinted with the `urgent`
+// color, which blinks every `blink` interval if set. Clicking such a desktop
+// activates the window.
+func (bar *Bar) workspaceBlock(block *Block, p params) error {
+	act := p.color("active", xgraphics.BGRA{B: 211, G: 167, R: 114, A: 0xFF})
+	empty := p.color("empty", xgraphics.BGRA{B: 228, G: 201, R: 169, A: 0xFF})
+	urg := p.color("urgent", xgraphics.BGRA{B: 79, G: 96, R: 211, A: 0xFF})
+	blink := p.duration("blink", 0)
+	pad := p.int("pad", 12)
+	il := p.strings("icons")
+	bg := block.bg
+	fg := block.fg
+
+	block.update = func(ctx context.Context) {
+		// The redraw function is called from both the X event loop and the
+		// blink ticker.
+		var mu sync.Mutex
+		lit := true
+		blinking := false
+
+		// Redraw block function.
+		t := func() {
+			mu.Lock()
+			defer mu.Unlock()
+
+			// Get the number of desktops, their names and the current active
+			// desktop. Desktop names are optional.
+			n, err := ewmh.NumberOfDesktopsGet(X)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			cur, err := ewmh.CurrentDesktopGet(X)
 			if err != nil {
-				log.Fatalln(err)
+				log.Println(err)
+				return
 			}
+			nl, _ := ewmh.DesktopNamesGet(X)
 
-			// Keep connection alive by pinging ever 45 seconds.
-			go func() {
-				for {
-					time.Sleep(time.Second * 45)
+			// Check which desktops have windows on them, and which desktops
+			// have a window that demands attention.
+			occ := make(map[uint]bool)
+			um := make(map[uint]xproto.Window)
+			cl, _ := ewmh.ClientListGet(X)
+			for _, id := range cl {
+				d, err := ewmh.WmDesktopGet(X, id)
+				if err != nil {
+					continue
+				}
+				occ[d] = true
+				if _, ok := um[d]; !ok && urgent(id) {
+					um[d] = id
+				}
+			}
+			blinking = blink > 0 && len(um) > 0
 
-					if err := bar.store["mpd"].(*mpd.Client).
-						Ping(); err != nil {
-						bar.store["mpd"], err = mpd.Dial("tcp", ":6600")
-						if err != nil {
-							log.Fatalln(err)
-						}
+			// Create a button for every desktop.
+			var b strings.Builder
+			for i := uint(0); i < n; i++ {
+				name := strconv.Itoa(int(i) + 1)
+				if int(i) < len(nl) && nl[i] != "" {
+					name = nl[i]
+				}
+				name = escapeMarkup(name)
+				if int(i) < len(il) {
+					name = il[i] + "   " + name
+				}
+
+				bc, fc := bg, fg
+				if i == cur {
+					bc = act
+				} else if !occ[i] {
+					fc = empty
+				}
+				a := "desktop " + strconv.Itoa(int(i))
+				if id, ok := um[i]; ok {
+					if lit || blink == 0 {
+						bc = urg
 					}
+					a = "window " + strconv.Itoa(int(id))
 				}
-			}()
 
-			// Watch MPD for events.
-			w, err := mpd.NewWatcher("tcp", ":6600", "", "player")
+				fmt.Fprintf(&b, "%%{B%s F%s A1:%s:}", formatColor(bc),
+					formatColor(fc), a)
+				fmt.Fprintf(&b, "%%{O%d}%s%%{O%d}%%{A}", pad, name, pad)
+			}
+
+			// Return if the text is the same.
+			block.mu.Lock()
+			same := b.String() == block.txt
+			block.txt = b.String()
+			block.mu.Unlock()
+			if same {
+				return
+			}
+
+			// Redraw block.
+			bar.paint(block)
+		}
+
+		// Function that stops listening to the previous clients, this is
+		// guarded by `mu`.
+		cancel := func() {}
+
+		// Client list change function.
+		f := func() {
+			// Stop listening to the previous clients.
+			mu.Lock()
+			cancel()
+			var cctx context.Context
+			cctx, cancel = context.WithCancel(ctx)
+			mu.Unlock()
+
+			// Listen to the clients for desktop and urgency changes, the
+			// window might be gone already so errors are ignored.
+			cl, _ := ewmh.ClientListGet(X)
+			for _, id := range cl {
+				watch(cctx, id, t, "_NET_WM_DESKTOP", "_NET_WM_STATE",
+					"WM_HINTS")
+			}
+
+			t()
+		}
+
+		// Listen for desktop and client list change events, execute `t()`
+		// and `f()` accordingly.
+		if err := watch(ctx, X.RootWin(), t, "_NET_NUMBER_OF_DESKTOPS",
+			"_NET_DESKTOP_NAMES", "_NET_CURRENT_DESKTOP"); err != nil {
+			log.Println(err)
+			return
+		}
+		if err := watch(ctx, X.RootWin(), f, "_NET_CLIENT_LIST"); err != nil {
+			log.Println(err)
+			return
+		}
+
+		// Execute `f()` one time initially.
+		f()
+
+		// Blink the urgent desktops.
+		if blink == 0 {
+			return
+		}
+		tk := time.NewTicker(blink)
+		defer tk.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tk.C:
+			}
+
+			mu.Lock()
+			lit = !lit || !blinking
+			b := blinking
+			mu.Unlock()
+			if b {
+				t()
+			}
+		}
+	}
+
+	return nil
+}
+
+// clockBlock is a block that displays the current time.
+func (bar *Bar) clockBlock(block *Block, p params) error {
+	fm := p.string("format", "Monday, January 2th 03:04 PM")
+	d := p.duration("interval", 45*time.Second)
+
+	block.update = func(ctx context.Context) {
+		for {
+			// Set new block text.
+			block.mu.Lock()
+			block.txt = time.Now().Format(fm)
+			block.mu.Unlock()
+
+			// Redraw block.
+			bar.paint(block)
+
+			// Update every interval.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d):
+			}
+		}
+	}
+
+	return nil
+}
+
+// musicBlock is a block that displays the current MPD song, and connects to
+// MPD again when the connection is lost. The `popup` parameter is the name of
+// the popup that should be updated alongside it.
+func (bar *Bar) musicBlock(block *Block, p params) error {
+	addr := p.string("address", ":6600")
+	pk := p.string("popup", "music")
+	pre := block.txt
+
+	block.update = func(ctx context.Context) {
+		// The delay before connecting again, this doubles after every failed
+		// attempt.
+		delay := time.Second
+
+		// Function that connects to MPD, and updates the block until the
+		// connection is lost or `ctx` is done.
+		run := func() error {
+			c, err := mpd.Dial("tcp", addr)
 			if err != nil {
-				log.Fatalln(err)
+				return err
 			}
+			defer c.Close()
+			w, err := mpd.NewWatcher("tcp", addr, "", "player")
+			if err != nil {
+				return err
+			}
+			defer w.Close()
+			bar.setStore("mpd", c)
+			defer bar.setStore("mpd", nil)
+			delay = time.Second
+
 			for {
-				cur, err := bar.store["mpd"].(*mpd.Client).CurrentSong()
+				cur, err := c.CurrentSong()
 				if err != nil {
-					log.Println(err)
-					return
+					return err
 				}
-				sts, err := bar.store["mpd"].(*mpd.Client).Status()
+				sts, err := c.Status()
 				if err != nil {
-					log.Println(err)
-					return
+					return err
 				}
 
 				// Set new block text.
@@ -351,65 +1524,59 @@ func (bar *Bar) initBlocks() {
 				if sts["state"] == "pause" {
 					s = "[paused] "
 				}
-				block.txt = " Ƅ  " + s + cur["Artist"] + " - " + cur["Title"]
+				txt := escapeMarkup(cur["Artist"] + " - " + cur["Title"])
+				block.mu.Lock()
+				block.txt = pre + s + txt
+				block.mu.Unlock()
 
 				// Redraw block.
-				bar.redraw <- block
+				bar.paint(block)
 
 				// Update popup if open.
-				if popup.open {
+				if popup := bar.popup(pk); popup != nil && popup.open {
 					popup.update()
 				}
 
-				// Wait for next event.
-				<-w.Event
-			}
-		},
-
-		actions: map[xproto.Button]func() error{
-			1: func() error {
-				return bar.drawPopup("music")
-			},
-			3: func() error {
-				s, err := bar.store["mpd"].(*mpd.Client).Status()
-				if err != nil {
+				// Wait for next event, and keep the connection alive by
+				// pinging every 45 seconds.
+				select {
+				case <-ctx.Done():
+					return nil
+				case <-w.Event:
+				case err := <-w.Error:
 					return err
+				case <-time.After(45 * time.Second):
+					if err := c.Ping(); err != nil {
+						return err
+					}
 				}
+			}
+		}
 
-				return bar.store["mpd"].(*mpd.Client).
-					Pause(s["state"] != "pause")
-			},
-			4: func() error {
-				return bar.store["mpd"].(*mpd.Client).Previous()
-			},
-			5: func() error {
-				return bar.store["mpd"].(*mpd.Client).Next()
-			},
-		},
-	})
-
-	bar.blocks.Set("todo", &Block{
-		txt:   "ƅ",
-		w:     bar.h,
-		align: 'c',
-		xoff:  1,
-		bg:    xgraphics.BGRA{B: 201, G: 148, R: 83, A: 0xFF},
-		fg:    xgraphics.BGRA{B: 255, G: 255, R: 255, A: 0xFF},
+		for {
+			err := run()
+			if ctx.Err() != nil {
+				return
+			}
+			log.Println(err)
 
-		update: func() {},
+			// Set new block text, and redraw block.
+			block.mu.Lock()
+			block.txt = pre + "no mpd"
+			block.mu.Unlock()
+			bar.paint(block)
 
-		actions: map[xproto.Button]func() error{
-			1: func() error {
-				u, err := user.Current()
-				if err != nil {
-					return err
-				}
+			// Wait before connecting again.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			if delay < time.Minute {
+				delay *= 2
+			}
+		}
+	}
 
-				cmd := exec.Command("st", "micro", "-savecursor", "false", path.
-					Join(u.HomeDir, ".todo"))
-				cmd.Stdout = os.Stdout
-				return cmd.Start()
-			},
-		},
-	})
+	return nil
 }
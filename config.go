@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/AndreKR/multiface"
+	"github.com/BurntSushi/toml"
+	"github.com/BurntSushi/xgbutil/xgraphics"
+	"github.com/fsnotify/fsnotify"
+	"github.com/rkoesters/xdg/basedir"
+)
+
+// Config is a struct with information about the bar, its blocks and its
+// popups, as read from the config file.
+type Config struct {
+	Bar    BarConfig     `toml:"bar"`
+	Blocks []BlockConfig `toml:"blocks"`
+	Popups []PopupConfig `toml:"popups"`
+
+	// The font face loaded from `Bar.Fonts`, this is shared by all bars.
+	face *multiface.Face
+}
+
+// BarConfig is a struct with information about the geometry and font of the
+// bar.
+type BarConfig struct {
+	// The position, width and height of the bar. The position is relative to
+	// the monitor, and a width of zero spans the whole monitor.
+	X int `toml:"x"`
+	Y int `toml:"y"`
+	W int `toml:"w"`
+	H int `toml:"h"`
+
+	// If the bar should be placed at the bottom of the monitor, in which case
+	// the y coordinate is relative to the bottom.
+	Bottom bool `toml:"bottom"`
+
+	// The monitors to place a bar on, see `monitor.match`. If empty, a
+	// single bar is placed relative to the screen.
+	Monitors []string `toml:"monitors"`
+
+	// The background color of the parts of the bar without blocks.
+	Bg string `toml:"bg"`
+
+	// The BDF fonts that should be used, in order of preference. Paths that
+	// don't exist on disk are read from the embedded runtime directory.
+	Fonts []string `toml:"fonts"`
+}
+
+// BlockConfig is a struct with information about a block, see `Block` for
+// the meaning of most fields.
+type BlockConfig struct {
+	// The key the block is stored under, used to reference the block.
+	Name string `toml:"name"`
+
+	// The module type that decides what the block displays.
+	Module string `toml:"module"`
+
+	Txt    string `toml:"txt"`
+	W      width  `toml:"w"`
+	Pad    int    `toml:"pad"`
+	Region string `toml:"region"`
+	Align  string `toml:"align"`
+	Xoff   int    `toml:"xoff"`
+	Bg     string `toml:"bg"`
+	Fg     string `toml:"fg"`
+
+	// If text that doesn't fit the block should scroll, the speed in pixels
+	// per second and the time to pause at both ends of the text.
+	Scroll bool   `toml:"scroll"`
+	Speed  int    `toml:"speed"`
+	Pause  string `toml:"pause"`
+
+	// The part of the block that is clickable, this can be `block` for the
+	// whole block or `text` for only its text.
+	Click string `toml:"click"`
+
+	// The monitors the block should be placed on, see `monitor.match`. If
+	// empty, the block is placed on all monitors.
+	Monitors []string `toml:"monitors"`
+
+	// Module specific parameters.
+	Params params `toml:"params"`
+
+	// A map with actions to execute on button events, the key is the button
+	// number.
+	Actions map[string]string `toml:"actions"`
+}
+
+// PopupConfig is a struct with information about a popup.
+type PopupConfig struct {
+	// The key the popup is stored under, used to reference the popup.
+	Name string `toml:"name"`
+
+	// The module type that decides what the popup displays.
+	Module string `toml:"module"`
+
+	// The width and height of the popup.
+	W int `toml:"w"`
+	H int `toml:"h"`
+
+	// The aligment of the popup relative to the bar, this can be `l` for
+	// left aligment, `c` for center aligment and `r` for right aligment.
+	Align string `toml:"align"`
+
+	// Additional x offset to further tweak the location of the popup.
+	Xoff int `toml:"xoff"`
+
+	// Module specific parameters.
+	Params params `toml:"params"`
+}
+
+// width is the width of a block, this can be a number of pixels, `fit` to fit
+// the text of the block or `fill` to fill the remaining space.
+type width struct {
+	// The size mode, see `Block`.
+	size rune
+
+	// The fixed width in pixels.
+	px int
+}
+
+func (w *width) UnmarshalTOML(v interface{}) error {
+	switch v := v.(type) {
+	case int64:
+		if v < 0 {
+			return fmt.Errorf("parse %d: Not a valid width", v)
+		}
+		w.size = 'x'
+		w.px = int(v)
+	case string:
+		switch v {
+		case "fit":
+			w.size = 'f'
+		case "fill":
+			w.size = '*'
+		default:
+			return fmt.Errorf("parse %q: Not a valid width", v)
+		}
+	default:
+		return fmt.Errorf("parse %v: Not a valid width", v)
+	}
+
+	return nil
+}
+
+// params is a map with module specific parameters.
+type params map[string]interface{}
+
+func configPath() string {
+	return path.Join(basedir.ConfigHome, "melonbar", "config.toml")
+}
+
+func loadConfig(fp string) (*Config, error) {
+	cfg := new(Config)
+
+	// Read the config file, if the user doesn't have a config file we fall
+	// back to the embedded default config.
+	b, err := os.ReadFile(fp)
+	if os.IsNotExist(err) {
+		b, err = runtime.ReadFile("runtime/config.toml")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := toml.Decode(string(b), cfg)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range md.Undecoded() {
+		// Module specific parameters are checked by the modules.
+		if len(k) > 1 && k[1] == "params" {
+			continue
+		}
+		log.Printf("config: Unknown key %q", k.String())
+	}
+
+	return cfg, nil
+}
+
+// watchConfig watches the config file for changes and listens for SIGHUP,
+// and executes `f` with the reloaded config.
+func watchConfig(fp string, f func(*Config)) {
+	// Listen for SIGHUP.
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGHUP)
+
+	// Watch the directory instead of the file itself, because a lot of
+	// editors replace the file instead of writing to it.
+	var ec <-chan fsnotify.Event
+	var errc <-chan error
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		log.Println(err)
+	} else {
+		defer w.Close()
+		if err := w.Add(path.Dir(fp)); err != nil {
+			log.Println(err)
+		}
+		ec = w.Events
+		errc = w.Errors
+	}
+
+	var t <-chan time.Time
+	for {
+		select {
+		case ev := <-ec:
+			if path.Clean(ev.Name) != path.Clean(fp) {
+				continue
+			}
+
+			// Wait a bit, editors tend to write the file in multiple steps.
+			t = time.After(100 * time.Millisecond)
+		case err := <-errc:
+			log.Println(err)
+		case <-sc:
+			t = time.After(0)
+		case <-t:
+			cfg, err := loadConfig(fp)
+			if err != nil {
+				log.Println(err)
+				continue
+			}
+			f(cfg)
+		}
+	}
+}
+
+func parseColor(s string) (xgraphics.BGRA, error) {
+	if len(s) != 7 || s[0] != '#' {
+		return xgraphics.BGRA{}, fmt.Errorf("parse %q: Not a valid color", s)
+	}
+
+	c, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return xgraphics.BGRA{}, fmt.Errorf("parse %q: Not a valid color", s)
+	}
+
+	return xgraphics.BGRA{B: uint8(c), G: uint8(c >> 8), R: uint8(c >> 16),
+		A: 0xFF}, nil
+}
+
+func formatColor(c xgraphics.BGRA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// rule is a rewrite rule for the title of a window.
+type rule struct {
+	// The regular expression the class or instance name of the window
+	// should match.
+	class *regexp.Regexp
+
+	// The regular expression in the title that is replaced with `replace`.
+	match   *regexp.Regexp
+	replace string
+}
+
+func parseRules(tl []params) ([]rule, error) {
+	var rl []rule
+	for _, t := range tl {
+		c, err := regexp.Compile(t.string("class", ""))
+		if err != nil {
+			return nil, err
+		}
+		m, err := regexp.Compile(t.string("match", ".*"))
+		if err != nil {
+			return nil, err
+		}
+
+		rl = append(rl, rule{class: c, match: m, replace: t.string("replace",
+			"")})
+	}
+	return rl, nil
+}
+
+func parseAlign(s string) (rune, error) {
+	if len(s) != 1 || !strings.ContainsAny(s, "lcr") {
+		return 0, fmt.Errorf("parse %q: Not a valid aligment", s)
+	}
+	return rune(s[0]), nil
+}
+
+func parseRegion(s string) (rune, error) {
+	switch s {
+	case "left":
+		return 'l', nil
+	case "center":
+		return 'c', nil
+	case "right":
+		return 'r', nil
+	}
+	return 0, fmt.Errorf("parse %q: Not a valid region", s)
+}
+
+func (p params) string(k, d string) string {
+	if v, ok := p[k].(string); ok {
+		return v
+	}
+	return d
+}
+
+func (p params) strings(k string) []string {
+	var l []string
+	if v, ok := p[k].([]interface{}); ok {
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				l = append(l, s)
+			}
+		}
+	}
+	return l
+}
+
+func (p params) tables(k string) []params {
+	var l []params
+	if v, ok := p[k].([]map[string]interface{}); ok {
+		for _, t := range v {
+			l = append(l, t)
+		}
+	}
+	return l
+}
+
+func (p params) int(k string, d int) int {
+	if v, ok := p[k].(int64); ok {
+		return int(v)
+	}
+	return d
+}
+
+func (p params) float(k string, d float64) float64 {
+	switch v := p[k].(type) {
+	case float64:
+		return v
+	case int64:
+		return float64(v)
+	}
+	return d
+}
+
+func (p params) bool(k string, d bool) bool {
+	if v, ok := p[k].(bool); ok {
+		return v
+	}
+	return d
+}
+
+func (p params) duration(k string, d time.Duration) time.Duration {
+	if v, ok := p[k].(string); ok {
+		if t, err := time.ParseDuration(v); err == nil {
+			return t
+		}
+		log.Printf("config: Not a valid duration %q", v)
+	}
+	return d
+}
+
+func (p params) color(k string, d xgraphics.BGRA) xgraphics.BGRA {
+	if v, ok := p[k].(string); ok {
+		c, err := parseColor(v)
+		if err == nil {
+			return c
+		}
+		log.Println(err)
+	}
+	return d
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/xgbutil/xgraphics"
+)
+
+func TestLoadConfig(t *testing.T) {
+	for _, tc := range []struct {
+		name, toml string
+		want       []width
+		err        bool
+	}{
+		{"valid", `
+[bar]
+h = 20
+
+[[blocks]]
+name = "a"
+module = "text"
+w = 100
+
+[[blocks]]
+name = "b"
+module = "text"
+w = "fit"
+
+[[blocks]]
+name = "c"
+module = "text"
+w = "fill"
+`, []width{{'x', 100}, {'f', 0}, {'*', 0}}, false},
+		{"no blocks", "[bar]\nh = 20\n", nil, false},
+		{"syntax", "[bar\nh = 20\n", nil, true},
+		{"negative width", `
+[[blocks]]
+name = "a"
+module = "text"
+w = -10
+`, nil, true},
+		{"unknown width", `
+[[blocks]]
+name = "a"
+module = "text"
+w = "wide"
+`, nil, true},
+		{"float width", `
+[[blocks]]
+name = "a"
+module = "text"
+w = 1.5
+`, nil, true},
+		{"wrong type", "[bar]\nh = \"twenty\"\n", nil, true},
+	} {
+		fp := path.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(fp, []byte(tc.toml), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := loadConfig(fp)
+		if tc.err {
+			if err == nil {
+				t.Errorf("loadConfig(%s): Expected an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("loadConfig(%s): %v", tc.name, err)
+			continue
+		}
+		var wl []width
+		for _, c := range cfg.Blocks {
+			wl = append(wl, c.W)
+		}
+		if !reflect.DeepEqual(wl, tc.want) {
+			t.Errorf("loadConfig(%s): Got widths %v, want %v", tc.name, wl,
+				tc.want)
+		}
+	}
+
+	// Without a config file the embedded default config is used.
+	cfg, err := loadConfig(path.Join(t.TempDir(), "config.toml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Blocks) == 0 {
+		t.Errorf("loadConfig: The default config has no blocks")
+	}
+}
+
+func TestWidth(t *testing.T) {
+	for _, tc := range []struct {
+		v    interface{}
+		want width
+		err  bool
+	}{
+		{int64(0), width{'x', 0}, false},
+		{int64(120), width{'x', 120}, false},
+		{"fit", width{'f', 0}, false},
+		{"fill", width{'*', 0}, false},
+		{int64(-1), width{}, true},
+		{"Fit", width{}, true},
+		{"", width{}, true},
+		{1.5, width{}, true},
+		{true, width{}, true},
+	} {
+		var w width
+		err := w.UnmarshalTOML(tc.v)
+		if tc.err {
+			if err == nil {
+				t.Errorf("UnmarshalTOML(%#v): Expected an error", tc.v)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("UnmarshalTOML(%#v): %v", tc.v, err)
+			continue
+		}
+		if w != tc.want {
+			t.Errorf("UnmarshalTOML(%#v) = %+v, want %+v", tc.v, w, tc.want)
+		}
+	}
+}
+
+func TestParseColor(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want xgraphics.BGRA
+		err  bool
+	}{
+		{"#000000", xgraphics.BGRA{A: 0xFF}, false},
+		{"#ffffff", xgraphics.BGRA{B: 0xFF, G: 0xFF, R: 0xFF, A: 0xFF},
+			false},
+		{"#72A7D3", xgraphics.BGRA{B: 0xD3, G: 0xA7, R: 0x72, A: 0xFF},
+			false},
+		{"72a7d3", xgraphics.BGRA{}, true},
+		{"#72a7d", xgraphics.BGRA{}, true},
+		{"#72a7d3ff", xgraphics.BGRA{}, true},
+		{"#72a7g3", xgraphics.BGRA{}, true},
+		{"#-72a7d", xgraphics.BGRA{}, true},
+		{"", xgraphics.BGRA{}, true},
+	} {
+		c, err := parseColor(tc.s)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseColor(%q): Expected an error", tc.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseColor(%q): %v", tc.s, err)
+			continue
+		}
+		if c != tc.want {
+			t.Errorf("parseColor(%q) = %+v, want %+v", tc.s, c, tc.want)
+		}
+		if s, want := formatColor(c), strings.ToLower(tc.s); s != want {
+			t.Errorf("formatColor(%+v) = %q, want %q", c, s, want)
+		}
+	}
+}
+
+func TestParseAlign(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want rune
+		err  bool
+	}{
+		{"l", 'l', false},
+		{"c", 'c', false},
+		{"r", 'r', false},
+		{"", 0, true},
+		{"x", 0, true},
+		{"lc", 0, true},
+		{"left", 0, true},
+	} {
+		r, err := parseAlign(tc.s)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseAlign(%q): Expected an error", tc.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseAlign(%q): %v", tc.s, err)
+			continue
+		}
+		if r != tc.want {
+			t.Errorf("parseAlign(%q) = %q, want %q", tc.s, r, tc.want)
+		}
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		want rune
+		err  bool
+	}{
+		{"left", 'l', false},
+		{"center", 'c', false},
+		{"right", 'r', false},
+		{"", 0, true},
+		{"l", 0, true},
+		{"middle", 0, true},
+		{"Left", 0, true},
+	} {
+		r, err := parseRegion(tc.s)
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseRegion(%q): Expected an error", tc.s)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseRegion(%q): %v", tc.s, err)
+			continue
+		}
+		if r != tc.want {
+			t.Errorf("parseRegion(%q) = %q, want %q", tc.s, r, tc.want)
+		}
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rl, err := parseRules([]params{
+		{"class": "^Firefox$", "match": " - Mozilla Firefox$"},
+		{"match": "^(.*) - Vim$", "replace": "vim: $1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rl) != 2 {
+		t.Fatalf("parseRules: Got %d rules, want 2", len(rl))
+	}
+
+	// The class defaults to matching everything, the replacement to
+	// nothing.
+	for i, tc := range []struct {
+		class, title, want string
+	}{
+		{"Firefox", "Home - Mozilla Firefox", "Home"},
+		{"firefox", "Home - Mozilla Firefox", "Home - Mozilla Firefox"},
+		{"URxvt", "main.go - Vim", "vim: main.go"},
+		{"", "notes - Vim", "vim: notes"},
+	} {
+		r := rl[0]
+		if i > 1 {
+			r = rl[1]
+		}
+		got := tc.title
+		if r.class.MatchString(tc.class) {
+			got = r.match.ReplaceAllString(tc.title, r.replace)
+		}
+		if got != tc.want {
+			t.Errorf("rule %q on %q = %q, want %q", r.match, tc.title, got,
+				tc.want)
+		}
+	}
+
+	// A missing match replaces the whole title.
+	rl, err = parseRules([]params{{"class": "^mpv$"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := rl[0].match.ReplaceAllString("video.mkv", rl[0].replace); s !=
+		"" {
+		t.Errorf("parseRules: Got %q, want an empty title", s)
+	}
+
+	// Invalid regular expressions are an error.
+	for _, p := range []params{{"class": "("}, {"match": "[a-"}} {
+		if _, err := parseRules([]params{p}); err == nil {
+			t.Errorf("parseRules(%v): Expected an error", p)
+		}
+	}
+}
+
+func TestParams(t *testing.T) {
+	p := params{
+		"s":     "text",
+		"l":     []interface{}{"a", int64(1), "b"},
+		"t":     []map[string]interface{}{{"k": "v"}, {"k": "w"}},
+		"i":     int64(12),
+		"f":     1.5,
+		"b":     true,
+		"d":     "1m30s",
+		"badd":  "soon",
+		"c":     "#72a7d3",
+		"badc":  "blue",
+		"wrong": []interface{}{},
+	}
+	def := xgraphics.BGRA{A: 0xFF}
+
+	if v := p.string("s", "d"); v != "text" {
+		t.Errorf("string(s) = %q, want %q", v, "text")
+	}
+	if v := p.string("i", "d"); v != "d" {
+		t.Errorf("string(i) = %q, want the default", v)
+	}
+	if v := p.strings("l"); !reflect.DeepEqual(v, []string{"a", "b"}) {
+		t.Errorf("strings(l) = %q, want %q", v, []string{"a", "b"})
+	}
+	if v := p.strings("s"); v != nil {
+		t.Errorf("strings(s) = %q, want nil", v)
+	}
+	if v := p.tables("t"); !reflect.DeepEqual(v, []params{{"k": "v"},
+		{"k": "w"}}) {
+		t.Errorf("tables(t) = %v, want two tables", v)
+	}
+	if v := p.tables("wrong"); v != nil {
+		t.Errorf("tables(wrong) = %v, want nil", v)
+	}
+	if v := p.int("i", 3); v != 12 {
+		t.Errorf("int(i) = %d, want 12", v)
+	}
+	if v := p.int("f", 3); v != 3 {
+		t.Errorf("int(f) = %d, want the default", v)
+	}
+	if v := p.float("f", 3); v != 1.5 {
+		t.Errorf("float(f) = %v, want 1.5", v)
+	}
+	if v := p.float("i", 3); v != 12 {
+		t.Errorf("float(i) = %v, want 12", v)
+	}
+	if v := p.float("s", 3); v != 3 {
+		t.Errorf("float(s) = %v, want the default", v)
+	}
+	if v := p.bool("b", false); !v {
+		t.Errorf("bool(b) = %t, want true", v)
+	}
+	if v := p.bool("missing", true); !v {
+		t.Errorf("bool(missing) = %t, want the default", v)
+	}
+	if v := p.duration("d", time.Second); v != 90*time.Second {
+		t.Errorf("duration(d) = %v, want 1m30s", v)
+	}
+	if v := p.duration("badd", time.Second); v != time.Second {
+		t.Errorf("duration(badd) = %v, want the default", v)
+	}
+	if v := p.color("c", def); v != (xgraphics.BGRA{B: 0xD3, G: 0xA7,
+		R: 0x72, A: 0xFF}) {
+		t.Errorf("color(c) = %+v, want #72a7d3", v)
+	}
+	if v := p.color("badc", def); v != def {
+		t.Errorf("color(badc) = %+v, want the default", v)
+	}
+}
+
+func TestInitBlocks(t *testing.T) {
+	for _, tc := range []struct {
+		name, toml string
+		want       []string
+		err        bool
+	}{
+		{"valid", `
+[[blocks]]
+name = "a"
+module = "text"
+region = "center"
+align = "r"
+bg = "#000000"
+fg = "#ffffff"
+click = "text"
+actions = { 1 = "desktop next" }
+
+[[blocks]]
+name = "b"
+module = "text"
+`, []string{"a", "b"}, false},
+		{"other monitor", `
+[[blocks]]
+name = "a"
+module = "text"
+monitors = ["HDMI-1"]
+
+[[blocks]]
+name = "b"
+module = "text"
+monitors = ["DP-1"]
+`, []string{"b"}, false},
+		{"bad color", `
+[[blocks]]
+name = "a"
+module = "text"
+bg = "#zzzzzz"
+`, nil, true},
+		{"unknown module", `
+[[blocks]]
+name = "a"
+module = "weather"
+`, nil, true},
+		{"duplicate name", `
+[[blocks]]
+name = "a"
+module = "text"
+
+[[blocks]]
+name = "a"
+module = "text"
+`, nil, true},
+		{"empty name", `
+[[blocks]]
+module = "text"
+`, nil, true},
+		{"bad region", `
+[[blocks]]
+name = "a"
+module = "text"
+region = "top"
+`, nil, true},
+		{"bad align", `
+[[blocks]]
+name = "a"
+module = "text"
+align = "center"
+`, nil, true},
+		{"bad click", `
+[[blocks]]
+name = "a"
+module = "text"
+click = "icon"
+`, nil, true},
+		{"bad pause", `
+[[blocks]]
+name = "a"
+module = "text"
+scroll = true
+pause = "long"
+`, nil, true},
+		{"bad button", `
+[[blocks]]
+name = "a"
+module = "text"
+actions = { left = "desktop next" }
+`, nil, true},
+		{"bad action", `
+[[blocks]]
+name = "a"
+module = "text"
+actions = { 1 = "jump" }
+`, nil, true},
+	} {
+		fp := path.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(fp, []byte(tc.toml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg, err := loadConfig(fp)
+		if err != nil {
+			t.Errorf("loadConfig(%s): %v", tc.name, err)
+			continue
+		}
+
+		bm, err := (&Bar{}).initBlocks(cfg.Blocks, monitor{name: "DP-1"})
+		if tc.err {
+			if err == nil {
+				t.Errorf("initBlocks(%s): Expected an error", tc.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("initBlocks(%s): %v", tc.name, err)
+			continue
+		}
+		var kl []string
+		for _, k := range bm.Keys() {
+			kl = append(kl, k.(string))
+		}
+		if !reflect.DeepEqual(kl, tc.want) {
+			t.Errorf("initBlocks(%s): Got blocks %q, want %q", tc.name, kl,
+				tc.want)
+		}
+	}
+}
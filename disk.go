@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// mount is a struct with information about a mounted file system, and its
+// usage.
+type mount struct {
+	// The device, mount point and file system type.
+	device, dir, fstype string
+
+	// The major and minor number of the device, for example `8:1`.
+	devnum string
+
+	// If the device is removable, like an USB stick.
+	removable bool
+
+	// The used, available and total bytes.
+	used, avail, total uint64
+}
+
+// readMounts reads the mounted file systems from `path`, which is in the
+// format of `/proc/self/mountinfo`. Removable devices are detected using
+// sysfs below `sys`.
+func readMounts(path, sys string) ([]mount, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// Every line consists of the mount ID, the parent ID, the device number,
+	// the root, the mount point and some optional fields. A separator is
+	// followed by the file system type and the device.
+	var ml []mount
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fl := strings.Fields(s.Text())
+		sep := -1
+		for i, v := range fl {
+			if v == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 5 || len(fl) < sep+3 {
+			continue
+		}
+
+		m := mount{
+			device: unescapeMount(fl[sep+2]),
+			dir:    unescapeMount(fl[4]),
+			fstype: fl[sep+1],
+			devnum: fl[2],
+		}
+		m.removable = removable(sys, m.devnum)
+		ml = append(ml, m)
+	}
+
+	return ml, s.Err()
+}
+
+// unescapeMount replaces the octal escapes of spaces, tabs, newlines and
+// backslashes in a field of `/proc/self/mountinfo`.
+func unescapeMount(s string) string {
+	return strings.NewReplacer(`\040`, " ", `\011`, "\t", `\012`, "\n",
+		`\134`, `\`).Replace(s)
+}
+
+// removable returns if the block device with the given device number is
+// removable, using sysfs below `sys`. Partitions are removable if the disk
+// they are on is.
+func removable(sys, devnum string) bool {
+	// The device number links to the device, the directory of a partition is
+	// inside the directory of its disk.
+	d, err := filepath.EvalSymlinks(filepath.Join(sys, "dev", "block", devnum))
+	if err != nil {
+		return false
+	}
+	if readSysfs(d, "removable") == "1" {
+		return true
+	}
+	if _, err := os.Stat(filepath.Join(d, "partition")); err == nil {
+		return readSysfs(filepath.Join(d, ".."), "removable") == "1"
+	}
+	return false
+}
+
+// usage reads the used, available and total bytes of the file system.
+func (m *mount) usage() error {
+	total, free, avail, err := statfs(m.dir)
+	if err != nil {
+		return err
+	}
+
+	// The available bytes exclude the blocks reserved for root.
+	m.total = total
+	m.avail = avail
+	m.used = total - free
+
+	return nil
+}
+
+// fraction returns the used fraction of the file system, as seen by normal
+// users.
+func (m mount) fraction() float64 {
+	if m.used+m.avail == 0 {
+		return 0
+	}
+	return float64(m.used) / float64(m.used+m.avail)
+}
+
+// diskStats is a struct that keeps the mounts of a disk block, and the
+// command that unmounts a removable device.
+type diskStats struct {
+	sync.Mutex
+
+	mounts  []mount
+	unmount string
+}
+
+// set stores the mounts.
+func (ds *diskStats) set(ml []mount) {
+	ds.Lock()
+	defer ds.Unlock()
+
+	ds.mounts = ml
+}
+
+// get returns the mounts.
+func (ds *diskStats) get() []mount {
+	ds.Lock()
+	defer ds.Unlock()
+
+	return append([]mount(nil), ds.mounts...)
+}
+
+// unmountAction returns the action that unmounts the mount, using the
+// unmount command in which `%d` is replaced by the device and `%m` by the
+// mount point.
+func (ds *diskStats) unmountAction(m mount) string {
+	q := func(s string) string {
+		return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+	}
+	return "exec " + strings.NewReplacer("%d", q(m.device), "%m", q(m.dir)).
+		Replace(ds.unmount)
+}
+
+// percent formats a fraction as a percentage, for example `42%`.
+func percent(v float64) string {
+	return strconv.Itoa(int(v*100+0.5)) + "%"
+}
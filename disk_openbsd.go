@@ -0,0 +1,24 @@
+//go:build openbsd
+// +build openbsd
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// statfs returns the total, free and available bytes of the file system
+// mounted on `dir`.
+func statfs(dir string) (total, free, avail uint64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, 0, 0, err
+	}
+
+	// The available blocks are negative once the reserved blocks are used.
+	bs := uint64(st.F_bsize)
+	if st.F_bavail > 0 {
+		avail = uint64(st.F_bavail) * bs
+	}
+	return st.F_blocks * bs, st.F_bfree * bs, avail, nil
+}
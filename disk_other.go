@@ -0,0 +1,14 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!openbsd
+
+package main
+
+import (
+	"fmt"
+)
+
+// statfs returns an error, the usage of file systems can't be read on this
+// platform.
+func statfs(dir string) (total, free, avail uint64, err error) {
+	return 0, 0, 0, fmt.Errorf("statfs %q: Not supported", dir)
+}
@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || linux
+// +build darwin dragonfly freebsd linux
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// statfs returns the total, free and available bytes of the file system
+// mounted on `dir`.
+func statfs(dir string) (total, free, avail uint64, err error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, 0, 0, err
+	}
+
+	// The types of the fields differ between platforms.
+	bs := uint64(st.Bsize)
+	return uint64(st.Blocks) * bs, uint64(st.Bfree) * bs, uint64(st.Bavail) *
+		bs, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnescapeMount(t *testing.T) {
+	for s, want := range map[string]string{
+		"/mnt/usb":                "/mnt/usb",
+		`/run/media/My\040Stick`:  "/run/media/My Stick",
+		`/mnt/a\011b\012c`:        "/mnt/a\tb\nc",
+		`/mnt/back\134slash`:      `/mnt/back\slash`,
+		`/mnt/back\134040literal`: `/mnt/back\040literal`,
+	} {
+		if u := unescapeMount(s); u != want {
+			t.Errorf("unescapeMount(%q) = %q, want %q", s, u, want)
+		}
+	}
+}
+
+func TestReadMounts(t *testing.T) {
+	ml, err := readMounts("testdata/proc/mountinfo", "testdata/sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Partitions are removable if their disk is.
+	want := []mount{
+		{device: "/dev/sda1", dir: "/", fstype: "ext4", devnum: "8:1"},
+		{device: "proc", dir: "/proc", fstype: "proc", devnum: "0:21"},
+		{device: "/dev/sdb1", dir: "/run/media/user/My Stick",
+			fstype: "vfat", devnum: "8:17", removable: true},
+		{device: "/dev/sdc", dir: "/mnt/back\\slash\ttab", fstype: "ext4",
+			devnum: "8:32", removable: true},
+		{device: "tmpfs", dir: "/tmp", fstype: "tmpfs", devnum: "0:40"},
+	}
+	if !reflect.DeepEqual(ml, want) {
+		t.Errorf("readMounts = %+v, want %+v", ml, want)
+	}
+}
+
+func TestMountFraction(t *testing.T) {
+	// Blocks reserved for root don't count as free.
+	m := mount{used: 60, avail: 20, total: 100}
+	if f := m.fraction(); f != 0.75 {
+		t.Errorf("fraction = %v, want 0.75", f)
+	}
+	if f := (mount{}).fraction(); f != 0 {
+		t.Errorf("fraction = %v, want 0", f)
+	}
+}
+
+func TestUnmountAction(t *testing.T) {
+	ds := &diskStats{unmount: "udisksctl unmount -b %d && rmdir %m"}
+	m := mount{device: "/dev/sdb1", dir: "/run/media/it's"}
+	want := `exec udisksctl unmount -b '/dev/sdb1' && rmdir ` +
+		`'/run/media/it'\''s'`
+	if a := ds.unmountAction(m); a != want {
+		t.Errorf("unmountAction = %q, want %q", a, want)
+	}
+}
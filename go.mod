@@ -6,15 +6,20 @@ require (
 	github.com/AndreKR/multiface v0.0.0-20190725194701-b414aa6424a8
 	github.com/BurntSushi/freetype-go v0.0.0-20160129220410-b763ddbfe298 // indirect
 	github.com/BurntSushi/graphics-go v0.0.0-20160129215708-b43f31a4a966 // indirect
+	github.com/BurntSushi/toml v1.3.2
 	github.com/BurntSushi/xgb v0.0.0-20201008132610-5f9e7b3c49cd
 	github.com/BurntSushi/xgbutil v0.0.0-20190907113008-ad855c713046
 	github.com/IvanMenshykov/MoonPhase v0.0.0-20180124195458-2a9432a62575
 	github.com/RadhiFadlillah/go-prayer v0.0.0-20200904044351-80665274d4b5
 	github.com/elliotchance/orderedmap v1.3.0
 	github.com/fhs/gompd v1.0.1
+	github.com/fsnotify/fsnotify v1.6.0
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
+	github.com/jfreymuth/pulse v0.1.1
 	github.com/rkoesters/xdg v0.0.0-20181125232953-edd15b846f9b
 	github.com/shopspring/decimal v1.2.0 // indirect
 	github.com/zachomedia/go-bdf v0.0.0-20200707041239-4d208bb116e0
 	golang.org/x/image v0.0.0-20201208152932-35266b937fa6
+	golang.org/x/sys v0.0.0-20220908164124-27713097b956
 )
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// sensor is a struct with a reading of a hwmon temperature or fan sensor.
+type sensor struct {
+	// The name of the chip and the label of the sensor, for example
+	// `coretemp` and `Package id 0`.
+	chip, label string
+
+	// The kind of sensor, `t` for temperature and `f` for fan sensors.
+	kind rune
+
+	// The temperature in degrees Celsius, or the fan speed in RPM.
+	value float64
+
+	// The critical temperature in degrees Celsius, zero if unknown.
+	crit float64
+}
+
+// name returns the name of the sensor, which is the chip and the label.
+func (s sensor) name() string {
+	return s.chip + "/" + s.label
+}
+
+// match returns if the sensor matches one of the patterns. A pattern can
+// match the label, or the name of the chip and the label separated by a
+// slash, for example `coretemp/Core *`.
+func (s sensor) match(pl []string) bool {
+	for _, p := range pl {
+		if ok, _ := path.Match(p, s.label); ok {
+			return true
+		}
+		if ok, _ := path.Match(p, s.name()); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// readSensors reads the temperature and fan sensors from the `hwmon` class
+// in sysfs below `root`. Sensors without a label are labeled after their
+// file, for example `temp1`.
+func readSensors(root string) ([]sensor, error) {
+	dl, err := filepath.Glob(filepath.Join(root, "class", "hwmon", "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	var sl []sensor
+	for _, d := range dl {
+		chip := readSysfs(d, "name")
+		if chip == "" {
+			chip = filepath.Base(d)
+		}
+
+		for _, k := range []string{"temp", "fan"} {
+			il, err := filepath.Glob(filepath.Join(d, k+"*_input"))
+			if err != nil {
+				return nil, err
+			}
+			for _, i := range il {
+				pre := strings.TrimSuffix(filepath.Base(i), "_input")
+				v, err := strconv.ParseFloat(readSysfs(d, pre+"_input"), 64)
+				if err != nil {
+					continue
+				}
+
+				s := sensor{chip: chip, label: readSysfs(d, pre+"_label"),
+					kind: 'f', value: v}
+				if s.label == "" {
+					s.label = pre
+				}
+
+				// Temperatures are in millidegrees Celsius.
+				if k == "temp" {
+					s.kind = 't'
+					s.value /= 1000
+					for _, c := range []string{"_crit", "_max"} {
+						if v, err := strconv.ParseFloat(readSysfs(d, pre+c),
+							64); err == nil && v > 0 {
+							s.crit = v / 1000
+							break
+						}
+					}
+				}
+
+				sl = append(sl, s)
+			}
+		}
+	}
+
+	// Sort the sensors, as the numbering of hwmon devices isn't stable.
+	sort.SliceStable(sl, func(i, j int) bool {
+		return sl[i].name() < sl[j].name()
+	})
+
+	return sl, nil
+}
+
+// sensorStats is a struct that keeps the selected sensors of a temperature
+// block, and a history of their readings.
+type sensorStats struct {
+	sync.Mutex
+
+	// The sensors read at the last sample.
+	sensors []sensor
+
+	// The history of every sensor, by name.
+	history map[string]*history
+}
+
+// add stores the sensors, and adds their readings to their history.
+func (ss *sensorStats) add(sl []sensor) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	ss.sensors = sl
+	for _, s := range sl {
+		if _, ok := ss.history[s.name()]; !ok {
+			ss.history[s.name()] = &history{max: 200}
+		}
+		ss.history[s.name()].add(s.value)
+	}
+}
+
+// get returns the sensors and their history.
+func (ss *sensorStats) get() ([]sensor, map[string][]float64) {
+	ss.Lock()
+	defer ss.Unlock()
+
+	hm := make(map[string][]float64)
+	for _, s := range ss.sensors {
+		if hl := ss.history[s.name()].get(); len(hl) > 0 {
+			hm[s.name()] = hl[0]
+		}
+	}
+	return append([]sensor(nil), ss.sensors...), hm
+}
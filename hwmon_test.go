@@ -0,0 +1,47 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadSensors(t *testing.T) {
+	sl, err := readSensors("testdata/sys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Chips without a name are named after their directory, sensors without
+	// a label after their file. A critical temperature of zero falls back to
+	// the maximum temperature.
+	want := []sensor{
+		{chip: "coretemp", label: "Core 0", kind: 't', value: 43.5,
+			crit: 80},
+		{chip: "coretemp", label: "Package id 0", kind: 't', value: 45,
+			crit: 100},
+		{chip: "hwmon1", label: "fan1", kind: 'f', value: 1200},
+		{chip: "hwmon1", label: "temp1", kind: 't', value: 38.5},
+	}
+	if !reflect.DeepEqual(sl, want) {
+		t.Errorf("readSensors = %+v, want %+v", sl, want)
+	}
+}
+
+func TestSensorMatch(t *testing.T) {
+	s := sensor{chip: "coretemp", label: "Core 0"}
+	for _, tc := range []struct {
+		pl   []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"Core 0"}, true},
+		{[]string{"Core *"}, true},
+		{[]string{"coretemp/*"}, true},
+		{[]string{"k10temp/*", "Package *"}, false},
+		{[]string{"k10temp/*", "coretemp/Core ?"}, true},
+	} {
+		if ok := s.match(tc.pl); ok != tc.want {
+			t.Errorf("match(%q) = %v, want %v", tc.pl, ok, tc.want)
+		}
+	}
+}
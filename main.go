@@ -1,10 +1,18 @@
 package main
 
 import (
-	"log"
 	"embed"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	"github.com/AndreKR/multiface"
 	"github.com/BurntSushi/xgbutil"
 )
 
@@ -15,34 +23,120 @@ var (
 	// Connection to the X server.
 	X *xgbutil.XUtil
 
-	// The multifont face that should be used.
-	face *multiface.Face
+	// Command-line flags, these override the values in the config file.
+	flagConfig = flag.String("config", configPath(),
+		"path to the config file")
+	flagGeometry = flag.String("geometry", "",
+		"bar geometry as `WxH+X+Y`, parts can be omitted")
+	flagFont = flag.String("font", "",
+		"comma separated list of BDF font paths")
+	flagBottom = flag.Bool("bottom", false,
+		"place the bar at the bottom of the monitor")
+	flagMonitor = flag.String("monitor", "",
+		"comma separated list of RandR outputs to place a bar on, `primary` "+
+			"or `*` for all")
 )
 
+// applyFlags overrides the values in the config with the values of the
+// command-line flags.
+func applyFlags(cfg *Config) error {
+	if *flagGeometry != "" {
+		m := regexp.MustCompile(`^(\d*)(?:x(\d*))?([+-]\d+)?([+-]\d+)?$`).
+			FindStringSubmatch(*flagGeometry)
+		if m == nil {
+			return fmt.Errorf("parse %q: Not a valid geometry", *flagGeometry)
+		}
+
+		for i, v := range []*int{&cfg.Bar.W, &cfg.Bar.H, &cfg.Bar.X,
+			&cfg.Bar.Y} {
+			if m[i+1] == "" {
+				continue
+			}
+			n, err := strconv.Atoi(m[i+1])
+			if err != nil {
+				return err
+			}
+			*v = n
+		}
+	}
+	if *flagFont != "" {
+		cfg.Bar.Fonts = strings.Split(*flagFont, ",")
+	}
+	if *flagBottom {
+		cfg.Bar.Bottom = true
+	}
+	if *flagMonitor != "" {
+		cfg.Bar.Monitors = strings.Split(*flagMonitor, ",")
+	}
+
+	return nil
+}
+
 func main() {
-	// Initialize X.
-	if err := initX(); err != nil {
+	// Parse the command-line flags.
+	flag.Parse()
+
+	// Load the config file.
+	cfg, err := loadConfig(*flagConfig)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := applyFlags(cfg); err != nil {
 		log.Fatalln(err)
 	}
 
-	// Initialize font face.
-	if err := initFace(); err != nil {
+	// Initialize X.
+	if err := initX(); err != nil {
 		log.Fatalln(err)
 	}
 
-	// Initialize bar.
-	bar, err := initBar(0, 0, 1920, 29)
-	if err != nil {
+	// Create a bar on every monitor, and initialize their font face, blocks
+	// and popups.
+	if err := syncBars(cfg, false); err != nil {
 		log.Fatalln(err)
 	}
 
-	// Initialize blocks and popups.
-	bar.initBlocks()
-	bar.initPopups()
+	// Reload the bars when the config file changes.
+	cc := make(chan *Config)
+	go watchConfig(*flagConfig, func(cfg *Config) {
+		if err := applyFlags(cfg); err != nil {
+			log.Println(err)
+			return
+		}
+		cc <- cfg
+	})
 
-	// Draw blocks.
-	go bar.drawBlocks()
+	// Sync the bars when a monitor is added, removed or changed.
+	mc := make(chan struct{}, 1)
+	watchMonitors(func() {
+		select {
+		case mc <- struct{}{}:
+		default:
+		}
+	})
 
-	// Listen for redraw events.
-	bar.listen()
+	// Hide or show the bars on SIGUSR1.
+	sc := make(chan os.Signal, 1)
+	signal.Notify(sc, syscall.SIGUSR1)
+
+	var t <-chan time.Time
+	for {
+		select {
+		case <-sc:
+			for _, bar := range bars {
+				bar.hide <- struct{}{}
+			}
+		case cfg = <-cc:
+			if err := syncBars(cfg, true); err != nil {
+				log.Println(err)
+			}
+		case <-mc:
+			// Wait a bit, RandR sends multiple events for a single change.
+			t = time.After(200 * time.Millisecond)
+		case <-t:
+			if err := syncBars(cfg, false); err != nil {
+				log.Println(err)
+			}
+		}
+	}
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestApplyFlags(t *testing.T) {
+	defer func(g string, b bool) {
+		*flagGeometry, *flagBottom = g, b
+	}(*flagGeometry, *flagBottom)
+
+	for _, tc := range []struct {
+		geometry string
+		want     BarConfig
+		err      bool
+	}{
+		{"", BarConfig{X: 1, Y: 2, W: 3, H: 4}, false},
+		{"200x20+10+5", BarConfig{X: 10, Y: 5, W: 200, H: 20}, false},
+		{"200", BarConfig{X: 1, Y: 2, W: 200, H: 4}, false},
+		{"x30", BarConfig{X: 1, Y: 2, W: 3, H: 30}, false},
+		{"x30-8", BarConfig{X: -8, Y: 2, W: 3, H: 30}, false},
+		{"+0-10", BarConfig{X: 0, Y: -10, W: 3, H: 4}, false},
+		{"200x20x10", BarConfig{}, true},
+		{"wide", BarConfig{}, true},
+	} {
+		*flagGeometry = tc.geometry
+		cfg := &Config{Bar: BarConfig{X: 1, Y: 2, W: 3, H: 4}}
+		err := applyFlags(cfg)
+		if tc.err {
+			if err == nil {
+				t.Errorf("applyFlags(%q): Expected an error", tc.geometry)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("applyFlags(%q): %v", tc.geometry, err)
+			continue
+		}
+		if !reflect.DeepEqual(cfg.Bar, tc.want) {
+			t.Errorf("applyFlags(%q) = %+v, want %+v", tc.geometry, cfg.Bar,
+				tc.want)
+		}
+	}
+
+	// The other flags override the config too.
+	*flagGeometry = ""
+	*flagBottom = true
+	cfg := &Config{}
+	if err := applyFlags(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Bar, BarConfig{Bottom: true}) {
+		t.Errorf("applyFlags: Got %+v, want a bottom bar", cfg.Bar)
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/xgraphics"
+)
+
+// run is a struct with information about a part of a text that shares the
+// same style.
+type run struct {
+	// The text of the run.
+	txt string
+
+	// The offset in pixels before the text.
+	off int
+
+	// The foreground and background colors.
+	fg, bg xgraphics.BGRA
+
+	// If the text should be underlined, and the color of the underline.
+	ul  bool
+	ulc xgraphics.BGRA
+
+	// A map with actions to execute on button events inside the run.
+	actions map[xproto.Button]string
+}
+
+// parseMarkup parses lemonbar-style inline markup into runs, the following
+// commands are available:
+//
+//	%{F#rrggbb} %{F-}          Set or reset the foreground color.
+//	%{B#rrggbb} %{B-}          Set or reset the background color.
+//	%{U} %{U#rrggbb} %{U-}     Start or stop underlining the text.
+//	%{+u} %{-u}                Start or stop underlining the text.
+//	%{O<n>}                    Offset the text by `n` pixels.
+//	%{A<n>:<action>:} %{A}     Start or stop a region that executes the
+//	                           action on button `n`, see `parseAction`.
+//	%%                         A literal `%`.
+//
+// Multiple commands can be combined, separated by spaces. Colons inside of an
+// action have to be escaped using `\:`.
+func parseMarkup(s string, fg, bg xgraphics.BGRA) []run {
+	var rl []run
+	cur := run{fg: fg, bg: bg, ulc: fg}
+	var stack []map[xproto.Button]string
+
+	// Function that ends the current run.
+	var b strings.Builder
+	flush := func() {
+		if b.Len() == 0 && cur.off == 0 {
+			return
+		}
+		cur.txt = b.String()
+		rl = append(rl, cur)
+
+		b.Reset()
+		cur.off = 0
+	}
+
+	for len(s) > 0 {
+		// Regular text.
+		if s[0] != '%' || len(s) == 1 {
+			i := strings.IndexByte(s[1:], '%') + 1
+			if i == 0 {
+				i = len(s)
+			}
+			b.WriteString(s[:i])
+			s = s[i:]
+			continue
+		}
+
+		// A literal `%`.
+		if s[1] == '%' {
+			b.WriteByte('%')
+			s = s[2:]
+			continue
+		}
+		if s[1] != '{' {
+			b.WriteByte('%')
+			s = s[1:]
+			continue
+		}
+
+		// A markup block, parse its commands.
+		flush()
+		s = s[2:]
+		for len(s) > 0 && s[0] != '}' {
+			if s[0] == ' ' {
+				s = s[1:]
+				continue
+			}
+
+			switch s[0] {
+			case 'A':
+				// Parse the button and action.
+				i := 1
+				for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+					i++
+				}
+				if i >= len(s) || s[i] != ':' {
+					// Stop the last region.
+					if len(stack) > 0 {
+						stack = stack[:len(stack)-1]
+					}
+					s = s[i:]
+					break
+				}
+				btn := 1
+				if i > 1 {
+					btn, _ = strconv.Atoi(s[1:i])
+				}
+				act, n := scanAction(s[i+1:])
+				s = s[i+1+n:]
+
+				stack = append(stack, map[xproto.Button]string{
+					xproto.Button(btn): act})
+			default:
+				// Parse the command until the next space or end of block.
+				i := strings.IndexAny(s, " }")
+				if i == -1 {
+					i = len(s)
+				}
+				cmd := s[:i]
+				s = s[i:]
+
+				switch {
+				case cmd == "F-":
+					cur.fg = fg
+				case cmd == "B-":
+					cur.bg = bg
+				case cmd == "U-", cmd == "-u":
+					cur.ul = false
+				case cmd == "U", cmd == "+u":
+					cur.ul = true
+				case cmd[0] == 'F':
+					if c, err := parseColor(cmd[1:]); err == nil {
+						cur.fg = c
+					}
+				case cmd[0] == 'B':
+					if c, err := parseColor(cmd[1:]); err == nil {
+						cur.bg = c
+					}
+				case cmd[0] == 'U':
+					if c, err := parseColor(cmd[1:]); err == nil {
+						cur.ul = true
+						cur.ulc = c
+					}
+				case cmd[0] == 'O':
+					if o, err := strconv.Atoi(cmd[1:]); err == nil {
+						cur.off += o
+					}
+				}
+			}
+		}
+		if len(s) > 0 {
+			s = s[1:]
+		}
+
+		// Merge the actions of all active regions, inner regions override
+		// outer regions.
+		cur.actions = nil
+		if len(stack) > 0 {
+			cur.actions = make(map[xproto.Button]string)
+			for _, m := range stack {
+				for k, v := range m {
+					cur.actions[k] = v
+				}
+			}
+		}
+	}
+	flush()
+
+	return rl
+}
+
+// scanAction scans an action until the next unescaped colon, and returns the
+// unescaped action and the number of bytes scanned, including the colon.
+func scanAction(s string) (string, int) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == ':':
+			b.WriteByte(':')
+			i++
+		case s[i] == ':':
+			return b.String(), i + 1
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), len(s)
+}
+
+// escapeMarkup escapes text so that it isn't parsed as markup.
+func escapeMarkup(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil/xgraphics"
+)
+
+func TestParseMarkup(t *testing.T) {
+	fg := xgraphics.BGRA{B: 1, G: 2, R: 3, A: 0xFF}
+	bg := xgraphics.BGRA{B: 4, G: 5, R: 6, A: 0xFF}
+	red := xgraphics.BGRA{R: 0xFF, A: 0xFF}
+
+	for _, tc := range []struct {
+		s    string
+		want []run
+	}{
+		{"plain", []run{{txt: "plain", fg: fg, bg: bg, ulc: fg}}},
+		{"%{F#ff0000}red%{F-}fg", []run{
+			{txt: "red", fg: red, bg: bg, ulc: fg},
+			{txt: "fg", fg: fg, bg: bg, ulc: fg},
+		}},
+		{"%{B#ff0000 U}a%{B- -u}b", []run{
+			{txt: "a", fg: fg, bg: red, ul: true, ulc: fg},
+			{txt: "b", fg: fg, bg: bg, ulc: fg},
+		}},
+		{"%{O10}a", []run{{txt: "a", off: 10, fg: fg, bg: bg, ulc: fg}}},
+		{`%{A3:exec echo a\:b:}x%{A}y`, []run{
+			{txt: "x", fg: fg, bg: bg, ulc: fg, actions: map[xproto.
+				Button]string{3: "exec echo a:b"}},
+			{txt: "y", fg: fg, bg: bg, ulc: fg},
+		}},
+		{"%{A:a:}%{A2:b:}x%{A}y%{A}", []run{
+			{txt: "x", fg: fg, bg: bg, ulc: fg, actions: map[xproto.
+				Button]string{1: "a", 2: "b"}},
+			{txt: "y", fg: fg, bg: bg, ulc: fg, actions: map[xproto.
+				Button]string{1: "a"}},
+		}},
+		{"100%", []run{{txt: "100%", fg: fg, bg: bg, ulc: fg}}},
+	} {
+		if rl := parseMarkup(tc.s, fg, bg); !reflect.DeepEqual(rl, tc.want) {
+			t.Errorf("parseMarkup(%q) = %+v, want %+v", tc.s, rl, tc.want)
+		}
+	}
+}
+
+func TestEscapeMarkup(t *testing.T) {
+	fg := xgraphics.BGRA{A: 0xFF}
+	for _, s := range []string{"", "plain", "100%", "%%", "%{F#ff0000}red",
+		"50% %{A:exec rm:}", "%"} {
+		var txt string
+		for _, r := range parseMarkup(escapeMarkup(s), fg, fg) {
+			if r.fg != fg || r.off != 0 || r.ul || r.actions != nil {
+				t.Errorf("parseMarkup(escapeMarkup(%q)): Markup was parsed",
+					s)
+			}
+			txt += r.txt
+		}
+		if txt != s {
+			t.Errorf("parseMarkup(escapeMarkup(%q)) = %q", s, txt)
+		}
+	}
+}
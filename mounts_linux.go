@@ -0,0 +1,55 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// watchMounts returns a channel that receives a value every time a file
+// system is mounted or unmounted, until `ctx` is done. The kernel signals
+// this by marking `path`, which should be a `mountinfo` file, with a priority
+// event.
+func watchMounts(ctx context.Context, path string) <-chan struct{} {
+	c := make(chan struct{}, 1)
+
+	f, err := os.Open(path)
+	if err != nil {
+		log.Println(err)
+		return c
+	}
+
+	go func() {
+		defer f.Close()
+
+		// Poll with a timeout, so that `ctx` is checked regularly. Polling
+		// again also checks the state of the file, in case a wakeup was
+		// missed.
+		fl := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLPRI}}
+		for ctx.Err() == nil {
+			n, err := unix.Poll(fl, 500)
+			if err == unix.EINTR {
+				continue
+			}
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			if n == 0 {
+				continue
+			}
+
+			select {
+			case c <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return c
+}
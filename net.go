@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// netIface is a struct with information about a network interface.
+type netIface struct {
+	// The name of the interface, for example `wlan0`.
+	name string
+
+	// If the link of the interface is up.
+	up bool
+
+	// The IPv4 and IPv6 addresses of the interface.
+	addrs []string
+
+	// The total bytes received and transmitted.
+	rx, tx uint64
+
+	// If the interface is wireless, the SSID and signal strength in percent
+	// of the network it is connected to.
+	wireless bool
+	ssid     string
+	signal   int
+}
+
+// ifaceLookup is a struct with the functions that read the parts of a network
+// interface that aren't in sysfs or procfs.
+type ifaceLookup struct {
+	// The functions that return the addresses of the interface, and the SSID
+	// of the network a wireless interface is connected to.
+	addrs func(name string) []string
+	ssid  func(name string) string
+}
+
+// hostLookup reads the addresses and SSIDs of the interfaces of the host.
+var hostLookup = ifaceLookup{addrs: ifaceAddrs, ssid: essid}
+
+// readIfaces reads the network interfaces from the `net` class in sysfs below
+// `sys`, and their statistics from `net/dev` and `net/wireless` below `proc`.
+// The addresses and SSIDs are read using `lu`. The loopback interface is
+// skipped.
+func readIfaces(sys, proc string, lu ifaceLookup) ([]netIface, error) {
+	dl, err := filepath.Glob(filepath.Join(sys, "class", "net", "*"))
+	if err != nil {
+		return nil, err
+	}
+	dev, err := readNetDev(proc)
+	if err != nil {
+		return nil, err
+	}
+	wl := readWireless(proc)
+
+	var il []netIface
+	for _, d := range dl {
+		i := netIface{name: filepath.Base(d)}
+		if i.name == "lo" {
+			continue
+		}
+
+		// An interface that doesn't report its operational state is up if it
+		// has a carrier.
+		switch readSysfs(d, "operstate") {
+		case "up":
+			i.up = true
+		case "unknown":
+			i.up = readSysfs(d, "carrier") == "1"
+		}
+
+		// Read the statistics.
+		i.rx, i.tx = dev[i.name][0], dev[i.name][1]
+		if s, ok := wl[i.name]; ok {
+			i.wireless = true
+			i.signal = s
+			i.ssid = lu.ssid(i.name)
+		} else if _, err := os.Stat(filepath.Join(d, "wireless")); err == nil {
+			i.wireless = true
+		}
+
+		// Read the addresses.
+		i.addrs = lu.addrs(i.name)
+
+		il = append(il, i)
+	}
+
+	return il, nil
+}
+
+// ifaceAddrs returns the IPv4 and IPv6 addresses of the interface.
+func ifaceAddrs(name string) []string {
+	ni, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil
+	}
+	al, err := ni.Addrs()
+	if err != nil {
+		return nil
+	}
+
+	var l []string
+	for _, a := range al {
+		if n, ok := a.(*net.IPNet); ok {
+			l = append(l, n.IP.String())
+		}
+	}
+	return l
+}
+
+// readNetDev reads the bytes received and transmitted by every interface from
+// `net/dev` below `root`.
+func readNetDev(root string) (map[string][2]uint64, error) {
+	f, err := os.Open(filepath.Join(root, "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The first two lines are a header, every other line is an interface name
+	// followed by eight receive and eight transmit fields.
+	m := make(map[string][2]uint64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fl := strings.Fields(kv[1])
+		if len(fl) < 16 {
+			continue
+		}
+		rx, err := strconv.ParseUint(fl[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: Not a valid byte count", fl[0])
+		}
+		tx, err := strconv.ParseUint(fl[8], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("parse %q: Not a valid byte count", fl[8])
+		}
+		m[strings.TrimSpace(kv[0])] = [2]uint64{rx, tx}
+	}
+
+	return m, s.Err()
+}
+
+// readWireless reads the signal strength in percent of every wireless
+// interface from `net/wireless` below `root`. Interfaces that aren't
+// connected to a network aren't listed.
+func readWireless(root string) map[string]int {
+	m := make(map[string]int)
+
+	b, err := os.ReadFile(filepath.Join(root, "net", "wireless"))
+	if err != nil {
+		return m
+	}
+
+	// The first two lines are a header, the third field is the link quality
+	// out of 70.
+	for _, l := range strings.Split(string(b), "\n") {
+		kv := strings.SplitN(l, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		fl := strings.Fields(kv[1])
+		if len(fl) < 2 {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSuffix(fl[1], "."), 64)
+		if err != nil {
+			continue
+		}
+		s := int(q / 70 * 100)
+		if s > 100 {
+			s = 100
+		}
+		m[strings.TrimSpace(kv[0])] = s
+	}
+
+	return m
+}
+
+// defaultIface returns the name of the interface of the default route, read
+// from `net/route` below `root`. It returns an empty string if there is no
+// default route.
+func defaultIface(root string) string {
+	b, err := os.ReadFile(filepath.Join(root, "net", "route"))
+	if err != nil {
+		return ""
+	}
+
+	// Pick the default route with the lowest metric.
+	name, metric := "", -1
+	for _, l := range strings.Split(string(b), "\n")[1:] {
+		fl := strings.Fields(l)
+		if len(fl) < 7 || fl[1] != "00000000" {
+			continue
+		}
+		m, err := strconv.Atoi(fl[6])
+		if err != nil {
+			continue
+		}
+		if metric == -1 || m < metric {
+			name, metric = fl[0], m
+		}
+	}
+
+	return name
+}
+
+// netStats is a struct that keeps the rates of every network interface, and
+// a history of them.
+type netStats struct {
+	sync.Mutex
+
+	// The interfaces read at the last sample, and the functions that read
+	// the parts of them that aren't in sysfs or procfs.
+	ifaces []netIface
+	lookup ifaceLookup
+
+	// The receive and transmit rates in bytes per second of every interface,
+	// and the time of the last sample.
+	rates map[string][2]float64
+	last  time.Time
+
+	// The history of the receive and transmit rates of every interface.
+	history map[string]*history
+}
+
+// sample reads the interfaces and updates the rates and their history.
+func (ns *netStats) sample(sys, proc string) ([]netIface, error) {
+	il, err := readIfaces(sys, proc, ns.lookup)
+	if err != nil {
+		return nil, err
+	}
+
+	ns.Lock()
+	defer ns.Unlock()
+
+	// Calculate the rates since the last sample.
+	now := time.Now()
+	prev := make(map[string]netIface)
+	for _, i := range ns.ifaces {
+		prev[i.name] = i
+	}
+	rates := make(map[string][2]float64)
+	for _, i := range il {
+		p, ok := prev[i.name]
+		if !ok || ns.last.IsZero() || i.rx < p.rx || i.tx < p.tx {
+			rates[i.name] = [2]float64{}
+		} else {
+			d := now.Sub(ns.last).Seconds()
+			rates[i.name] = [2]float64{float64(i.rx-p.rx) / d, float64(i.tx-
+				p.tx) / d}
+		}
+
+		if _, ok := ns.history[i.name]; !ok {
+			ns.history[i.name] = &history{max: 200}
+		}
+		ns.history[i.name].add(rates[i.name][0], rates[i.name][1])
+	}
+	ns.ifaces, ns.rates, ns.last = il, rates, now
+
+	return il, nil
+}
+
+// get returns the interfaces sorted by name, their rates and their history.
+func (ns *netStats) get() ([]netIface, map[string][2]float64,
+	map[string][][]float64) {
+	ns.Lock()
+	defer ns.Unlock()
+
+	il := append([]netIface(nil), ns.ifaces...)
+	sort.Slice(il, func(i, j int) bool {
+		return il[i].name < il[j].name
+	})
+	hm := make(map[string][][]float64)
+	for _, i := range il {
+		hm[i.name] = ns.history[i.name].get()
+	}
+
+	return il, ns.rates, hm
+}
+
+// formatRate formats a rate in bytes per second, for example `1.5M`.
+func formatRate(r float64) string {
+	return formatKB(uint64(r / 1024))
+}
@@ -0,0 +1,50 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"syscall"
+	"unsafe"
+)
+
+// iwPoint is `struct iw_point`, the member of the `iwreq_data` union that
+// points to a buffer.
+type iwPoint struct {
+	pointer unsafe.Pointer
+	length  uint16
+	flags   uint16
+}
+
+// essid returns the SSID of the network the wireless interface is connected
+// to using the `SIOCGIWESSID` ioctl of the wireless extensions. It returns an
+// empty string if the SSID can't be read.
+func essid(name string) string {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.
+		SOCK_CLOEXEC, 0)
+	if err != nil {
+		return ""
+	}
+	defer syscall.Close(fd)
+
+	// This is `struct iwreq`, the `iwreq_data` union is 16 bytes on every
+	// architecture, and `struct iw_point` is only 8 bytes on 32-bit ones.
+	const siocgiwessid = 0x8B1B
+	b := make([]byte, 33)
+	req := struct {
+		name [16]byte
+		data iwPoint
+		_    [16 - unsafe.Sizeof(iwPoint{})]byte
+	}{data: iwPoint{pointer: unsafe.Pointer(&b[0]), length: uint16(len(b))}}
+	copy(req.name[:15], name)
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd),
+		siocgiwessid, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return ""
+	}
+	if int(req.data.length) < len(b) {
+		b = b[:req.data.length]
+	}
+	return string(bytes.TrimRight(b, "\x00"))
+}
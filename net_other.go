@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// essid returns an empty string, the SSID is only read using the wireless
+// extensions of Linux.
+func essid(name string) string {
+	return ""
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadNetDev(t *testing.T) {
+	m, err := readNetDev("testdata/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][2]uint64{
+		"lo":    {123456, 123456},
+		"eth0":  {0, 0},
+		"wlan0": {1234567890, 98765432},
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("readNetDev = %v, want %v", m, want)
+	}
+}
+
+func TestReadWireless(t *testing.T) {
+	// The link quality is out of 70, and is limited to 100%.
+	want := map[string]int{"wlan0": 70, "wlan1": 100}
+	if m := readWireless("testdata/proc"); !reflect.DeepEqual(m, want) {
+		t.Errorf("readWireless = %v, want %v", m, want)
+	}
+
+	// Without wireless extensions there are no wireless interfaces.
+	if m := readWireless("testdata"); len(m) != 0 {
+		t.Errorf("readWireless = %v, want no interfaces", m)
+	}
+}
+
+func TestDefaultIface(t *testing.T) {
+	// The default route with the lowest metric wins.
+	if n := defaultIface("testdata/proc"); n != "eth0" {
+		t.Errorf("defaultIface = %q, want %q", n, "eth0")
+	}
+	if n := defaultIface("testdata"); n != "" {
+		t.Errorf("defaultIface = %q, want no interface", n)
+	}
+}
+
+// fakeLookup returns addresses and SSIDs for `eth0` and `wlan0`.
+var fakeLookup = ifaceLookup{
+	addrs: func(name string) []string {
+		return map[string][]string{
+			"eth0":  {"fe80::1"},
+			"wlan0": {"192.168.1.10", "fe80::2"},
+		}[name]
+	},
+	ssid: func(name string) string {
+		return map[string]string{"wlan0": "home"}[name]
+	},
+}
+
+func TestReadIfaces(t *testing.T) {
+	il, err := readIfaces("testdata/sys", "testdata/proc", fakeLookup)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The loopback interface and the interfaces that aren't in sysfs are
+	// skipped, interfaces without statistics didn't receive or transmit
+	// anything.
+	want := []netIface{
+		{name: "eth0", addrs: []string{"fe80::1"}},
+		{name: "usb0", up: true},
+		{name: "wlan0", up: true, addrs: []string{"192.168.1.10", "fe80::2"},
+			rx: 1234567890, tx: 98765432, wireless: true, ssid: "home",
+			signal: 70},
+		{name: "wlan2", wireless: true},
+	}
+	if !reflect.DeepEqual(il, want) {
+		t.Errorf("readIfaces = %+v, want %+v", il, want)
+	}
+
+	// Without statistics the interfaces can't be read.
+	if _, err := readIfaces("testdata/sys", "testdata", fakeLookup); err ==
+		nil {
+		t.Error("readIfaces: Expected an error")
+	}
+}
+
+func TestNetStats(t *testing.T) {
+	ns := &netStats{lookup: fakeLookup, history: make(map[string]*history)}
+
+	// The first sample has no rates.
+	if _, err := ns.sample("testdata/sys", "testdata/proc"); err != nil {
+		t.Fatal(err)
+	}
+	_, rm, _ := ns.get()
+	if r := rm["wlan0"]; r != [2]float64{} {
+		t.Errorf("get: Got rates %v, want none", r)
+	}
+
+	// Pretend the last sample was two seconds ago and received less bytes.
+	ns.last = ns.last.Add(-2 * time.Second)
+	ns.ifaces[2].rx -= 2048
+	ns.ifaces[2].tx -= 1024
+	if _, err := ns.sample("testdata/sys", "testdata/proc"); err != nil {
+		t.Fatal(err)
+	}
+	il, rm, hm := ns.get()
+	if len(il) != 4 || il[2].name != "wlan0" {
+		t.Fatalf("get: Got interfaces %+v", il)
+	}
+	if r := rm["wlan0"]; math.Abs(r[0]-1024) > 1 || math.Abs(r[1]-512) > 1 {
+		t.Errorf("get: Got rates %v, want about [1024 512]", r)
+	}
+	if len(hm["wlan0"]) == 0 {
+		t.Error("get: No history")
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// The D-Bus name, path and interface of the notification daemon, see
+// https://specifications.freedesktop.org/notification-spec/.
+const (
+	notifyName = "org.freedesktop.Notifications"
+	notifyPath = "/org/freedesktop/Notifications"
+)
+
+// The reasons a notification can be closed for.
+const (
+	closeExpired   = 1
+	closeDismissed = 2
+	closeCalled    = 3
+)
+
+// notification is a struct with information about a notification.
+type notification struct {
+	// The ID of the notification, and the time it was received.
+	id   uint32
+	time time.Time
+
+	// The name of the application that sent the notification, and its
+	// summary and body.
+	app, summary, body string
+
+	// The urgency of the notification, 0 for low, 1 for normal and 2 for
+	// critical urgency.
+	urgency byte
+
+	// The actions of the notification, as pairs of keys and labels.
+	actions []string
+
+	// If the notification has expired or has been closed.
+	closed bool
+}
+
+// notifier is a notification daemon that owns `org.freedesktop.Notifications`
+// on a D-Bus connection, and keeps a history of notifications.
+type notifier struct {
+	// A mutex that guards the notifier, this isn't embedded because all
+	// exported methods are exported on D-Bus.
+	mu sync.Mutex
+
+	// The D-Bus connection.
+	conn *dbus.Conn
+
+	// The history of notifications, the newest notification is last. The
+	// length of the history is limited to `max` notifications.
+	history []*notification
+	max     int
+
+	// The last used notification ID.
+	id uint32
+
+	// The expire timeout of notifications that don't specify one.
+	timeout time.Duration
+
+	// The channels that are notified when the notifications change.
+	subs map[chan struct{}]bool
+}
+
+var (
+	// The notification daemon, this is started the first time it is used.
+	notifyd   *notifier
+	notifydMu sync.Mutex
+)
+
+// notifications returns the notification daemon, it is started on the
+// session bus the first time it is used. If starting it fails, it is started
+// again the next time it is used.
+func notifications() (*notifier, error) {
+	notifydMu.Lock()
+	defer notifydMu.Unlock()
+
+	if notifyd != nil {
+		return notifyd, nil
+	}
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+	n, err := newNotifier(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	notifyd = n
+
+	return n, nil
+}
+
+// newNotifier exports the notification daemon on the connection, and
+// requests the notification daemon name.
+func newNotifier(conn *dbus.Conn) (*notifier, error) {
+	n := &notifier{
+		conn:    conn,
+		max:     50,
+		timeout: 5 * time.Second,
+		subs:    make(map[chan struct{}]bool),
+	}
+
+	if err := conn.Export(n, notifyPath, notifyName); err != nil {
+		return nil, err
+	}
+	r, err := conn.RequestName(notifyName, dbus.NameFlagDoNotQueue)
+	if err != nil {
+		return nil, err
+	}
+	if r != dbus.RequestNameReplyPrimaryOwner {
+		return nil, fmt.Errorf("notify: Another notification daemon is " +
+			"running")
+	}
+
+	return n, nil
+}
+
+// GetCapabilities implements the D-Bus method.
+func (n *notifier) GetCapabilities() ([]string, *dbus.Error) {
+	return []string{"body", "actions"}, nil
+}
+
+// GetServerInformation implements the D-Bus method.
+func (n *notifier) GetServerInformation() (string, string, string, string,
+	*dbus.Error) {
+	return "melonbar", "melonbar", "1.0", "1.2", nil
+}
+
+// Notify implements the D-Bus method.
+func (n *notifier) Notify(app string, rid uint32, icon, summary, body string,
+	actions []string, hints map[string]dbus.Variant, timeout int32) (uint32,
+	*dbus.Error) {
+	no := &notification{
+		time:    time.Now(),
+		app:     app,
+		summary: summary,
+		body:    body,
+		urgency: 1,
+		actions: actions,
+	}
+	if v, ok := hints["urgency"].Value().(byte); ok {
+		no.urgency = v
+	}
+
+	n.mu.Lock()
+
+	// Replace the notification if requested, otherwise use a new ID.
+	no.id = rid
+	if rid == 0 || n.index(rid) == -1 {
+		n.id++
+		no.id = n.id
+	} else {
+		n.history = append(n.history[:n.index(rid)], n.history[n.index(
+			rid)+1:]...)
+	}
+	n.history = append(n.history, no)
+	if len(n.history) > n.max {
+		n.history = n.history[len(n.history)-n.max:]
+	}
+
+	// Expire the notification. Critical notifications only expire if they
+	// ask for it.
+	d := time.Duration(timeout) * time.Millisecond
+	if timeout < 0 {
+		d = n.timeout
+		if no.urgency == 2 {
+			d = 0
+		}
+	}
+	if d > 0 {
+		time.AfterFunc(d, func() {
+			n.expire(no)
+		})
+	}
+
+	n.mu.Unlock()
+	n.changed()
+
+	return no.id, nil
+}
+
+// CloseNotification implements the D-Bus method.
+func (n *notifier) CloseNotification(id uint32) *dbus.Error {
+	n.mu.Lock()
+	i := n.index(id)
+	if i == -1 {
+		n.mu.Unlock()
+		return nil
+	}
+	n.history[i].closed = true
+	n.mu.Unlock()
+
+	n.emit("NotificationClosed", id, uint32(closeCalled))
+	n.changed()
+	return nil
+}
+
+// expire closes the notification once its timeout passed, it stays in the
+// history.
+func (n *notifier) expire(no *notification) {
+	n.mu.Lock()
+	if i := n.index(no.id); i == -1 || n.history[i] != no || no.closed {
+		n.mu.Unlock()
+		return
+	}
+	no.closed = true
+	n.mu.Unlock()
+
+	n.emit("NotificationClosed", no.id, uint32(closeExpired))
+	n.changed()
+}
+
+// dismiss removes the notification from the history. If `id` is zero, the
+// newest open notification is dismissed.
+func (n *notifier) dismiss(id uint32) {
+	n.mu.Lock()
+	i := -1
+	if id == 0 {
+		for j := len(n.history) - 1; j >= 0; j-- {
+			if !n.history[j].closed {
+				i = j
+				break
+			}
+		}
+	} else {
+		i = n.index(id)
+	}
+	if i == -1 {
+		n.mu.Unlock()
+		return
+	}
+	no := n.history[i]
+	n.history = append(n.history[:i], n.history[i+1:]...)
+	n.mu.Unlock()
+
+	if !no.closed {
+		n.emit("NotificationClosed", no.id, uint32(closeDismissed))
+	}
+	n.changed()
+}
+
+// clear removes all notifications from the history.
+func (n *notifier) clear() {
+	n.mu.Lock()
+	hl := n.history
+	n.history = nil
+	n.mu.Unlock()
+
+	for _, no := range hl {
+		if !no.closed {
+			n.emit("NotificationClosed", no.id, uint32(closeDismissed))
+		}
+	}
+	n.changed()
+}
+
+// invoke invokes the action of the notification, and dismisses it.
+func (n *notifier) invoke(id uint32, key string) {
+	n.emit("ActionInvoked", id, key)
+	n.dismiss(id)
+}
+
+// action returns if the notification has the action with the given key.
+func (no notification) action(key string) bool {
+	for i := 0; i+1 < len(no.actions); i += 2 {
+		if no.actions[i] == key {
+			return true
+		}
+	}
+	return false
+}
+
+// list returns a copy of the history, the newest notification is first.
+func (n *notifier) list() []notification {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	nl := make([]notification, 0, len(n.history))
+	for i := len(n.history) - 1; i >= 0; i-- {
+		nl = append(nl, *n.history[i])
+	}
+	return nl
+}
+
+// subscribe returns a channel that receives a value every time the
+// notifications change, until `ctx` is done.
+func (n *notifier) subscribe(ctx context.Context) <-chan struct{} {
+	c := make(chan struct{}, 1)
+
+	n.mu.Lock()
+	n.subs[c] = true
+	n.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		n.mu.Lock()
+		delete(n.subs, c)
+		n.mu.Unlock()
+	}()
+
+	return c
+}
+
+// changed notifies the subscribers.
+func (n *notifier) changed() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for c := range n.subs {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// emit emits a signal of the notification interface.
+func (n *notifier) emit(name string, v ...interface{}) {
+	n.conn.Emit(notifyPath, notifyName+"."+name, v...)
+}
+
+// index returns the index of the notification with the given ID in the
+// history, or -1 if it isn't in the history. The notifier should be locked.
+func (n *notifier) index(id uint32) int {
+	for i, no := range n.history {
+		if no.id == id {
+			return i
+		}
+	}
+	return -1
+}
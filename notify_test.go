@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// startBus starts a private session bus, and returns its address.
+func startBus(t *testing.T) string {
+	if _, err := exec.LookPath("dbus-daemon"); err != nil {
+		t.Skip("dbus-daemon not found")
+	}
+
+	cmd := exec.Command("dbus-daemon", "--session", "--nofork",
+		"--print-address")
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	addr, err := bufio.NewReader(out).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(addr)
+}
+
+// connect connects to the bus, and closes the connection when the test ends.
+func connect(t *testing.T, addr string) *dbus.Conn {
+	conn, err := dbus.Connect(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+	})
+	return conn
+}
+
+func TestNotifier(t *testing.T) {
+	addr := startBus(t)
+	n, err := newNotifier(connect(t, addr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.mu.Lock()
+	n.timeout = time.Hour
+	n.mu.Unlock()
+
+	// Only one notification daemon can run on a bus.
+	if _, err := newNotifier(connect(t, addr)); err == nil {
+		t.Error("newNotifier: Expected an error for a second daemon")
+	}
+
+	// Listen to the signals of the daemon as a client.
+	c := connect(t, addr)
+	if err := c.AddMatchSignal(dbus.WithMatchInterface(
+		notifyName)); err != nil {
+		t.Fatal(err)
+	}
+	sc := make(chan *dbus.Signal, 10)
+	c.Signal(sc)
+	signal := func(name string, v ...interface{}) {
+		t.Helper()
+		select {
+		case s := <-sc:
+			if s.Name != notifyName+"."+name || len(s.Body) != len(v) {
+				t.Fatalf("Got signal %s %v, want %s %v", s.Name, s.Body, name,
+					v)
+			}
+			for i := range v {
+				if s.Body[i] != v[i] {
+					t.Fatalf("Got signal %s %v, want %s %v", s.Name, s.Body,
+						name, v)
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for signal %s", name)
+		}
+	}
+	notify := func(rid uint32, summary string, actions []string,
+		timeout int32) uint32 {
+		t.Helper()
+		var id uint32
+		if err := c.Object(notifyName, notifyPath).Call(notifyName+
+			".Notify", 0, "test", rid, "", summary, "body", actions,
+			map[string]dbus.Variant{}, timeout).Store(&id); err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	// A notification with a replace ID replaces the notification with that
+	// ID, and keeps the ID.
+	id := notify(0, "first", nil, -1)
+	if rid := notify(id, "second", nil, -1); rid != id {
+		t.Errorf("Notify: Replacing %d returned %d", id, rid)
+	}
+	if nl := n.list(); len(nl) != 1 || nl[0].id != id || nl[0].summary !=
+		"second" {
+		t.Errorf("Notify: Got %+v after replacing", nl)
+	}
+	if rid := notify(id+100, "third", nil, -1); rid == id+100 {
+		t.Errorf("Notify: Replacing an unknown ID kept the ID")
+	}
+
+	// A notification with a timeout expires, but stays in the history.
+	eid := notify(0, "expires", nil, 10)
+	signal("NotificationClosed", eid, uint32(closeExpired))
+	for _, no := range n.list() {
+		if no.id == eid && !no.closed {
+			t.Error("expire: Notification isn't closed")
+		}
+	}
+
+	// Dismissing a notification removes it from the history.
+	n.dismiss(id)
+	signal("NotificationClosed", id, uint32(closeDismissed))
+	for _, no := range n.list() {
+		if no.id == id {
+			t.Error("dismiss: Notification is still in the history")
+		}
+	}
+
+	// Invoking an action dismisses the notification.
+	aid := notify(0, "action", []string{"default", "Open"}, -1)
+	if nl := n.list(); !nl[0].action("default") || nl[0].action("Open") {
+		t.Errorf("action: Got the wrong actions for %v", nl[0].actions)
+	}
+	n.invoke(aid, "default")
+	signal("ActionInvoked", aid, "default")
+	signal("NotificationClosed", aid, uint32(closeDismissed))
+}
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"image"
+	"log"
 
 	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/xevent"
 	"github.com/BurntSushi/xgbutil/xgraphics"
 	"github.com/BurntSushi/xgbutil/xwindow"
 	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
 )
 
 // Popup is a struct with information about the popup.
@@ -18,18 +23,31 @@ type Popup struct {
 	// The position, width and height of the popup.
 	x, y, w, h int
 
+	// The aligment of the popup relative to the bar, this can be `l` for left
+	// aligment, `c` for center aligment and `r` for right aligment.
+	align rune
+
+	// Additional x offset to further tweak the location of the popup.
+	xoff int
+
 	// Text drawer.
 	drawer *font.Drawer
 
 	// If the popup is currently open or not.
 	open bool
 
+	// The clickable regions the popup defined the last time it was drawn.
+	regions []region
+
 	// The fuction that updates the block, this will be executes as a goroutine.
 	update func()
 }
 
 func (bar *Bar) drawPopup(key string) error {
 	popup := bar.popup(key)
+	if popup == nil {
+		return fmt.Errorf("popup %q: Does not exist", key)
+	}
 
 	// If the popup is already open, we destroy it.
 	if popup.open {
@@ -37,6 +55,28 @@ func (bar *Bar) drawPopup(key string) error {
 		return nil
 	}
 
+	// Copy the geometry and config of the bar, these are replaced when the
+	// bar is moved or the config file is reloaded.
+	bar.mu.RLock()
+	bx, by, bw, bh := bar.x, bar.y, bar.w, bar.h
+	bc := bar.cfg.Bar
+	bar.mu.RUnlock()
+
+	// Calculate the required position for the different aligments.
+	switch popup.align {
+	case 'c':
+		popup.x = bx + (bw / 2) - (popup.w / 2) + popup.xoff
+	case 'r':
+		popup.x = bx + bw - popup.w + popup.xoff
+	default:
+		popup.x = bx + popup.xoff
+	}
+	// Open the popup above the bar if the bar is at the bottom.
+	popup.y = by + bh
+	if bc.Bottom {
+		popup.y = by - popup.h
+	}
+
 	// Create a window for the popup. This window listens to button press
 	// events in order to respond to them.
 	var err error
@@ -52,6 +92,9 @@ func (bar *Bar) drawPopup(key string) error {
 		return err
 	}
 
+	// Listen to mouse events.
+	bar.listenPopupClicks(popup)
+
 	// Map window.
 	popup.win.Map()
 
@@ -68,17 +111,54 @@ func (bar *Bar) drawPopup(key string) error {
 	// Set popup font face.
 	popup.drawer = &font.Drawer{
 		Dst:  popup.img,
-		Face: face,
+		Face: bar.getFace(),
 	}
 
-	// Run update function.
+	// Run update function. If it didn't draw the popup, for example because
+	// MPD isn't connected, destroy the window again.
 	popup.update()
+	if !popup.open {
+		popup.destroy()
+	}
 
 	return nil
 }
 
+// listenPopupClicks listens to mouse events on the popup, and executes the
+// action of the clicked region.
+func (bar *Bar) listenPopupClicks(popup *Popup) {
+	xevent.ButtonPressFun(func(_ *xgbutil.XUtil, ev xevent.ButtonPressEvent) {
+		pt := image.Pt(int(ev.EventX), int(ev.EventY))
+		for i := len(popup.regions) - 1; i >= 0; i-- {
+			r := popup.regions[i]
+			a, ok := r.actions[ev.Detail]
+			if !ok || !pt.In(r.rect) {
+				continue
+			}
+
+			f, err := bar.parseAction(a)
+			if err != nil {
+				log.Println(err)
+				return
+			}
+			go func() {
+				if err := f(); err != nil {
+					log.Println(err)
+				}
+			}()
+			return
+		}
+	}).Connect(X, popup.win.Id)
+}
+
 func (bar *Bar) popup(key string) *Popup {
-	i, _ := bar.popups.Get(key)
+	bar.mu.RLock()
+	defer bar.mu.RUnlock()
+
+	i, ok := bar.popups.Get(key)
+	if !ok {
+		return nil
+	}
 	return i.(*Popup)
 }
 
@@ -90,6 +170,21 @@ func (popup *Popup) draw() {
 	popup.open = true
 }
 
+// text draws the text at the given position with color `c`, and returns its
+// width.
+func (popup *Popup) text(txt string, x, y int, c xgraphics.BGRA) int {
+	popup.drawer.Src = image.NewUniform(c)
+	popup.drawer.Dot = fixed.P(x, y)
+	popup.drawer.DrawString(txt)
+	return popup.drawer.MeasureString(txt).Ceil()
+}
+
+// truncate shortens the text so that it fits inside `w` pixels when drawn
+// with the font face of the popup, see `truncate`.
+func (popup *Popup) truncate(txt string, w int, mode rune) string {
+	return truncate(popup.drawer.Face, txt, w, mode)
+}
+
 // TODO: I don't know if this actually frees memory and shit.
 func (popup *Popup) destroy() {
 	popup.win.Destroy()
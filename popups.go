@@ -1,14 +1,17 @@
 package main
 
 import (
+	"fmt"
 	"image"
 	"log"
 	"math"
 	"os"
 	"path"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/BurntSushi/xgb/xproto"
 	"github.com/BurntSushi/xgbutil/xgraphics"
 	"github.com/IvanMenshykov/MoonPhase"
 	"github.com/RadhiFadlillah/go-prayer"
@@ -18,381 +21,945 @@ import (
 	"golang.org/x/image/math/fixed"
 )
 
-func (bar *Bar) initPopups() {
-	bar.popups.Set("clock", &Popup{
-		x: (bar.w / 2) - (184 / 2),
-		y: bar.h,
-		w: 184,
-		h: 118,
+// popupModules maps a module name to the function that sets up a popup of
+// that type.
+var popupModules = map[string]func(*Bar, *Popup, params) error{
+	"clock":   (*Bar).clockPopup,
+	"music":   (*Bar).musicPopup,
+	"notify":  (*Bar).notifyPopup,
+	"cpu":     (*Bar).cpuPopup,
+	"network": (*Bar).networkPopup,
+	"volume":  (*Bar).volumePopup,
+	"disk":    (*Bar).diskPopup,
+	"sensors": (*Bar).sensorsPopup,
+}
 
-		update: func() {
-			popup := bar.popup("clock")
+// The default background, foreground, dimmed foreground and accent colors of
+// the popups.
+var (
+	popupBg     = xgraphics.BGRA{B: 238, G: 238, R: 238, A: 0xFF}
+	popupFg     = xgraphics.BGRA{B: 33, G: 27, R: 2, A: 0xFF}
+	popupDim    = xgraphics.BGRA{B: 128, G: 118, R: 112, A: 0xFF}
+	popupAccent = xgraphics.BGRA{B: 211, G: 167, R: 114, A: 0xFF}
+)
 
-			// Color the background.
-			popup.img.For(func(cx, cy int) xgraphics.BGRA {
-				return xgraphics.BGRA{B: 238, G: 238, R: 238, A: 0xFF}
-			})
+func (bar *Bar) initPopups(cl []PopupConfig) (*orderedmap.OrderedMap, error) {
+	pm := orderedmap.NewOrderedMap()
+	for _, c := range cl {
+		if _, ok := pm.Get(c.Name); ok || c.Name == "" {
+			return nil, fmt.Errorf("popup %q: Not a valid or unique name", c.Name)
+		}
+
+		popup := &Popup{
+			w:    c.W,
+			h:    c.H,
+			xoff: c.Xoff,
+		}
+
+		// Parse the aligment.
+		popup.align = 'l'
+		if c.Align != "" {
+			var err error
+			if popup.align, err = parseAlign(c.Align); err != nil {
+				return nil, fmt.Errorf("popup %q: %v", c.Name, err)
+			}
+		}
 
-			// Set foreground color.
-			popup.drawer.Src = image.NewUniform(xgraphics.BGRA{B: 33, G: 27,
-				R: 2, A: 0xFF})
-
-			// Get the current time. Present Day, heh... Present Time! Hahahaha!
-			n := time.Now()
-
-			// Get moon phase.
-			mc := MoonPhase.New(n)
-			mn := map[int]string{
-				0: "Ɔ",
-				1: "Ƈ",
-				2: "ƈ",
-				3: "Ɖ",
-				4: "Ɗ",
-				5: "Ƌ",
-				6: "ƌ",
-				7: "ƍ",
-				8: "Ƈ",
-			}
-
-			// Draw moon text.
-			popup.drawer.Dot = fixed.P(19, 48)
-			popup.drawer.DrawString("The moon currently looks like: " + mn[int(
-				math.Floor((mc.Phase()+0.0625)*8))])
-
-			// Get the prayers.
-			pm := (&prayer.Calculator{
-				Latitude:          52.1277,
-				Longitude:         5.6686,
-				Elevation:         21,
-				CalculationMethod: prayer.MWL,
-				AsrConvention:     prayer.Hanafi,
-				PreciseToSeconds:  false,
-			}).Init().SetDate(n).Calculate()
-
-			// The prayers we want to track.
-			pom := orderedmap.NewOrderedMap()
-			pom.Set("Fajr", pm[prayer.Fajr])
-			pom.Set("Zuhr", pm[prayer.Zuhr])
-			pom.Set("Asr", pm[prayer.Asr])
-			pom.Set("Maghrib", pm[prayer.Maghrib])
-			pom.Set("Isha", pm[prayer.Isha])
-
-			// Calculate the dot lenght, this is the length of the line (164px)
-			// divided by 2400 (minutes in a day).
-			d := 164.00 / 2400.00
-
-			// Calculate elapsed line length.
-			e := int(math.Round(d*float64(n.Hour()*100+n.Minute()))) + 10
-
-			// Draw line.
-			popup.img.SubImage(image.Rect(10, 101, 10+164, 102)).(*xgraphics.
-				Image).For(func(x, y int) xgraphics.BGRA {
-				// Make the line look dashed.
-				if x%5 == 4 {
-					return xgraphics.BGRA{B: 238, G: 238, R: 238, A: 0xFF}
-				}
+		// Let the module set up the popup.
+		m, ok := popupModules[c.Module]
+		if !ok {
+			return nil, fmt.Errorf("popup %q: Unknown module %q", c.Name, c.Module)
+		}
+		if err := m(bar, popup, c.Params); err != nil {
+			return nil, fmt.Errorf("popup %q: %v", c.Name, err)
+		}
 
-				if x < e {
-					return xgraphics.BGRA{B: 33, G: 27, R: 2, A: 0xFF}
-				}
-				return xgraphics.BGRA{B: 211, G: 167, R: 114, A: 0xFF}
-			})
+		pm.Set(c.Name, popup)
+	}
 
-			// Loop over these prayers and draw stuff for each one.
-			tm := false
-			np := false
-			for p := pom.Front(); p != nil; p = p.Next() {
-				k := p.Key.(string)
-				v := p.Value.(time.Time)
-
-				// Calculate arrow position.
-				pd := int(math.Round(d*float64(v.Hour()*100+v.Minute()))) + 9
-
-				// Set arrow color.
-				popup.drawer.Src = image.NewUniform(xgraphics.BGRA{B: 211,
-					G: 167, R: 114, A: 0xFF})
-
-				if tm || (!np && v.Unix() > n.Add(-time.Hour).Unix()) {
-					np = true
-
-					// Set arrow color for next prayer.
-					popup.drawer.Src = image.NewUniform(xgraphics.BGRA{B: 33,
-						G: 27, R: 2, A: 0xFF})
-
-					// Compose arrow text.
-					s := k + ", " + v.Format("03:04 PM")
-
-					// Calculate X offset, we use some smart logic in order to
-					// always have nice padding, even with longer strings.
-					sl := popup.drawer.MeasureString(s).Round()
-					x := pd + 2 - (sl / 2)
-					if x < 10 {
-						x = 10
-					} else if x > 184-8-sl {
-						x = 184 - 8 - sl
-					}
+	return pm, nil
+}
+
+// clockPopup is a popup that displays the moon phase and prayer times.
+func (bar *Bar) clockPopup(popup *Popup, p params) error {
+	lat := p.float("latitude", 52.1277)
+	lon := p.float("longitude", 5.6686)
+	ele := p.float("elevation", 21)
+	bg := p.color("bg", popupBg)
+	fg := p.color("fg", popupFg)
+	accent := p.color("accent", popupAccent)
+
+	popup.update = func() {
+		// Color the background.
+		popup.img.For(func(cx, cy int) xgraphics.BGRA {
+			return bg
+		})
+
+		// Set foreground color.
+		popup.drawer.Src = image.NewUniform(fg)
+
+		// Get the current time. Present Day, heh... Present Time! Hahahaha!
+		n := time.Now()
+
+		// Get moon phase.
+		mc := MoonPhase.New(n)
+		mn := map[int]string{
+			0: "Ɔ",
+			1: "Ƈ",
+			2: "ƈ",
+			3: "Ɖ",
+			4: "Ɗ",
+			5: "Ƌ",
+			6: "ƌ",
+			7: "ƍ",
+			8: "Ƈ",
+		}
+
+		// Draw moon text.
+		popup.drawer.Dot = fixed.P(19, 48)
+		popup.drawer.DrawString("The moon currently looks like: " + mn[int(
+			math.Floor((mc.Phase()+0.0625)*8))])
+
+		// Get the prayers.
+		pm := (&prayer.Calculator{
+			Latitude:          lat,
+			Longitude:         lon,
+			Elevation:         ele,
+			CalculationMethod: prayer.MWL,
+			AsrConvention:     prayer.Hanafi,
+			PreciseToSeconds:  false,
+		}).Init().SetDate(n).Calculate()
+
+		// The prayers we want to track.
+		pom := orderedmap.NewOrderedMap()
+		pom.Set("Fajr", pm[prayer.Fajr])
+		pom.Set("Zuhr", pm[prayer.Zuhr])
+		pom.Set("Asr", pm[prayer.Asr])
+		pom.Set("Maghrib", pm[prayer.Maghrib])
+		pom.Set("Isha", pm[prayer.Isha])
+
+		// Calculate the dot lenght, this is the length of the line (164px)
+		// divided by 2400 (minutes in a day).
+		d := 164.00 / 2400.00
+
+		// Calculate elapsed line length.
+		e := int(math.Round(d*float64(n.Hour()*100+n.Minute()))) + 10
+
+		// Draw line.
+		popup.img.SubImage(image.Rect(10, 101, 10+164, 102)).(*xgraphics.
+			Image).For(func(x, y int) xgraphics.BGRA {
+			// Make the line look dashed.
+			if x%5 == 4 {
+				return bg
+			}
 
-					// Draw arrow text.
-					popup.drawer.Dot = fixed.P(x, 85)
-					popup.drawer.DrawString(s)
+			if x < e {
+				return fg
+			}
+			return accent
+		})
+
+		// Loop over these prayers and draw stuff for each one.
+		tm := false
+		np := false
+		for p := pom.Front(); p != nil; p = p.Next() {
+			k := p.Key.(string)
+			v := p.Value.(time.Time)
+
+			// Calculate arrow position.
+			pd := int(math.Round(d*float64(v.Hour()*100+v.Minute()))) + 9
+
+			// Set arrow color.
+			popup.drawer.Src = image.NewUniform(accent)
+
+			if tm || (!np && v.Unix() > n.Add(-time.Hour).Unix()) {
+				np = true
+
+				// Set arrow color for next prayer.
+				popup.drawer.Src = image.NewUniform(fg)
+
+				// Compose arrow text.
+				s := k + ", " + v.Format("03:04 PM")
+
+				// Calculate X offset, we use some smart logic in order to
+				// always have nice padding, even with longer strings.
+				sl := popup.drawer.MeasureString(s).Round()
+				x := pd + 2 - (sl / 2)
+				if x < 10 {
+					x = 10
+				} else if x > 184-8-sl {
+					x = 184 - 8 - sl
 				}
 
-				// Workaround if the next prayer is tomorrow.
-				if p.Next() == nil && !np {
-					tm = true
+				// Draw arrow text.
+				popup.drawer.Dot = fixed.P(x, 85)
+				popup.drawer.DrawString(s)
+			}
 
-					pom.Delete("Fajr")
-					pom.Set("Fajr", pm[prayer.Fajr])
-				}
+			// Workaround if the next prayer is tomorrow.
+			if p.Next() == nil && !np {
+				tm = true
 
-				// Draw arrow.
-				popup.drawer.Dot = fixed.P(pd+2, 98)
-				popup.drawer.DrawString("↓")
+				pom.Delete("Fajr")
+				pom.Set("Fajr", pm[prayer.Fajr])
 			}
 
-			// Redraw the popup.
-			popup.draw()
-		},
-	})
+			// Draw arrow.
+			popup.drawer.Dot = fixed.P(pd+2, 98)
+			popup.drawer.DrawString("↓")
+		}
 
-	bar.popups.Set("music", &Popup{
-		x: bar.w - 327 - bar.h,
-		y: bar.h,
-		w: 327,
-		h: 148,
+		// Redraw the popup.
+		popup.draw()
+	}
 
-		update: func() {
-			popup := bar.popup("music")
+	return nil
+}
 
-			cur, err := bar.store["mpd"].(*mpd.Client).CurrentSong()
+// musicPopup is a popup that displays information about the current MPD song,
+// it requires a music block.
+func (bar *Bar) musicPopup(popup *Popup, p params) error {
+	bg := p.color("bg", popupBg)
+	fg := p.color("fg", popupFg)
+	accent := p.color("accent", popupAccent)
+
+	popup.update = func() {
+		c, ok := bar.getStore("mpd").(*mpd.Client)
+		if !ok {
+			return
+		}
+
+		cur, err := c.CurrentSong()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		sts, err := c.Status()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		// Color the background.
+		popup.img.For(func(cx, cy int) xgraphics.BGRA {
+			return bg
+		})
+
+		// Set foreground color.
+		popup.drawer.Src = image.NewUniform(fg)
+
+		// Draw album text.
+		album := popup.truncate(cur["Album"], 160, 'e')
+		popup.drawer.Dot = fixed.P(-(popup.drawer.MeasureString(album).
+			Ceil()/2)+90, 48)
+		popup.drawer.DrawString(album)
+
+		// Draw artist text.
+		artist := popup.truncate("Artist: "+cur["AlbumArtist"], 160, 'e')
+		popup.drawer.Dot = fixed.P(-(popup.drawer.MeasureString(artist).
+			Ceil()/2)+90, 58+16)
+		popup.drawer.DrawString(artist)
+
+		// Draw rlease date text.
+		date := popup.truncate("Release date: "+cur["Date"], 160, 'e')
+		popup.drawer.Dot = fixed.P(-(popup.drawer.MeasureString(date).
+			Ceil()/2)+90, 58+16+16)
+		popup.drawer.DrawString(date)
+
+		// Check if the cover art file exists.
+		fp := path.Join(userdirs.Music, path.Dir(cur["file"]),
+			"cover_popup.png")
+		if _, err := os.Stat(fp); !os.IsNotExist(err) {
+			f, err := os.Open(fp)
 			if err != nil {
 				log.Println(err)
 				return
 			}
-			sts, err := bar.store["mpd"].(*mpd.Client).Status()
+			defer f.Close()
+
+			// Draw cover art.
+			img, _, err := image.Decode(f)
 			if err != nil {
 				log.Println(err)
 				return
 			}
+			xgraphics.Blend(popup.img, xgraphics.NewConvert(X, img), image.
+				Point{-179, -0})
+		} else {
+			popup.drawer.Dot = fixed.P(218, 78)
+			popup.drawer.DrawString("No cover found!")
+		}
+
+		// Get elapsed and duration times.
+		se, err := strconv.ParseFloat(sts["elapsed"], 32)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		//e := int(math.Round(se))
+
+		// Calculate the dot lenght, this is the length of the line divided
+		// by the length of the song.
+		sd, err := strconv.ParseFloat(sts["duration"], 32)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		d := 159.00 / sd
+
+		// Calculate elapsed line length.
+		e := int(math.Round(d*se)) + 10
+
+		// Draw line.
+		popup.img.SubImage(image.Rect(10, 131, 10+159, 132)).(*xgraphics.
+			Image).For(func(x, y int) xgraphics.BGRA {
+			// Make the line look dashed.
+			if x%5 == 4 {
+				return bg
+			}
+
+			if x < e {
+				return fg
+			}
+			return accent
+		})
+
+		// Redraw the popup.
+		popup.draw()
+	}
+
+	return nil
+}
+
+// notifyPopup is a popup that displays the notification history, newest
+// first, with critical notifications marked in the `critical` color. Clicking
+// a notification invokes its default action, or dismisses it if it has none,
+// and the other actions are drawn as buttons below the body.
+func (bar *Bar) notifyPopup(popup *Popup, p params) error {
+	bg := p.color("bg", popupBg)
+	fg := p.color("fg", popupFg)
+	dim := p.color("dim", popupDim)
+	crit := p.color("critical", xgraphics.BGRA{B: 79, G: 96, R: 211,
+		A: 0xFF})
+
+	popup.update = func() {
+		n, err := notifications()
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		// Color the background.
+		popup.img.For(func(cx, cy int) xgraphics.BGRA {
+			return bg
+		})
+		popup.regions = nil
+
+		nl := n.list()
+		if len(nl) == 0 {
+			popup.text("No notifications", 10, 20, dim)
+		}
+
+		// Draw the notifications, as long as they fit.
+		y := 0
+		for _, no := range nl {
+			h := 34
+			if len(no.actions) > 2 || len(no.actions) == 2 && !no.action(
+				"default") {
+				h += 14
+			}
+			if y+h > popup.h {
+				break
+			}
+			id := strconv.Itoa(int(no.id))
 
-			// Color the background.
-			popup.img.For(func(cx, cy int) xgraphics.BGRA {
-				return xgraphics.BGRA{B: 238, G: 238, R: 238, A: 0xFF}
+			// Invoke the default action when the notification is clicked,
+			// or dismiss it if it has none.
+			a := "notify dismiss " + id
+			if no.action("default") {
+				a = "notify invoke " + id + " default"
+			}
+			popup.regions = append(popup.regions, region{
+				rect: image.Rect(0, y, popup.w, y+h),
+				actions: map[xproto.Button]string{
+					1: a,
+				},
 			})
 
-			// Set foreground color.
-			popup.drawer.Src = image.NewUniform(xgraphics.BGRA{B: 33, G: 27,
-				R: 2, A: 0xFF})
-
-			// Draw album text.
-			album := trim(cur["Album"], 32)
-			popup.drawer.Dot = fixed.P(-(popup.drawer.MeasureString(album).
-				Ceil()/2)+90, 48)
-			popup.drawer.DrawString(album)
-
-			// Draw artist text.
-			artist := trim("Artist: "+cur["AlbumArtist"], 32)
-			popup.drawer.Dot = fixed.P(-(popup.drawer.MeasureString(artist).
-				Ceil()/2)+90, 58+16)
-			popup.drawer.DrawString(artist)
-
-			// Draw rlease date text.
-			date := trim("Release date: "+cur["Date"], 32)
-			popup.drawer.Dot = fixed.P(-(popup.drawer.MeasureString(date).
-				Ceil()/2)+90, 58+16+16)
-			popup.drawer.DrawString(date)
-
-			// Check if the cover art file exists.
-			fp := path.Join(userdirs.Music, path.Dir(cur["file"]),
-				"cover_popup.png")
-			if _, err := os.Stat(fp); !os.IsNotExist(err) {
-				f, err := os.Open(fp)
-				if err != nil {
-					log.Println(err)
-					return
-				}
-				defer f.Close()
+			// Mark critical notifications.
+			if no.urgency == 2 {
+				fill(popup.img, image.Rect(0, y, 3, y+h), crit)
+			}
 
-				// Draw cover art.
-				img, _, err := image.Decode(f)
-				if err != nil {
-					log.Println(err)
-					return
+			// Draw the time, summary and body.
+			tm := no.time.Format("15:04")
+			tw := popup.drawer.MeasureString(tm).Ceil()
+			popup.text(tm, popup.w-10-tw, y+16, dim)
+			popup.text(popup.truncate(no.summary, popup.w-30-tw, 'e'), 10,
+				y+16, fg)
+			popup.text(popup.truncate(strings.Join(strings.Fields(no.body),
+				" "), popup.w-20, 'e'), 10, y+30, dim)
+
+			// Draw the action buttons, the default action has none.
+			x := 10
+			for i := 0; i+1 < len(no.actions); i += 2 {
+				if no.actions[i] == "default" {
+					continue
 				}
-				xgraphics.Blend(popup.img, xgraphics.NewConvert(X, img), image.
-					Point{-179, -0})
-			} else {
-				popup.drawer.Dot = fixed.P(218, 78)
-				popup.drawer.DrawString("No cover found!")
+				l := "[" + no.actions[i+1] + "]"
+				w := popup.text(l, x, y+44, fg)
+				popup.regions = append(popup.regions, region{
+					rect: image.Rect(x, y+34, x+w, y+h),
+					actions: map[xproto.Button]string{
+						1: "notify invoke " + id + " " + no.actions[i],
+					},
+				})
+				x += w + 8
 			}
 
-			// Get elapsed and duration times.
-			se, err := strconv.ParseFloat(sts["elapsed"], 32)
-			if err != nil {
-				log.Println(err)
-				return
+			// Draw a separator line.
+			y += h
+			fill(popup.img, image.Rect(0, y-1, popup.w, y), dim)
+		}
+
+		// Redraw the popup.
+		popup.draw()
+	}
+
+	return nil
+}
+
+// cpuPopup is a popup that gives every CPU core an equal share of its height,
+// with the current usage on the left and a sparkline of the usage history on
+// the right.
+func (bar *Bar) cpuPopup(popup *Popup, p params) error {
+	bg := p.color("bg", popupBg)
+	fg := p.color("fg", popupFg)
+	dim := p.color("dim", popupDim)
+
+	popup.update = func() {
+		h, ok := bar.getStore("cpu").(*history)
+		if !ok {
+			log.Println("popup \"cpu\": Requires a cpu block")
+			return
+		}
+
+		// Color the background.
+		popup.img.For(func(cx, cy int) xgraphics.BGRA {
+			return bg
+		})
+
+		// Give every core an equal share of the height of the popup.
+		sl := h.get()
+		if len(sl) == 0 {
+			popup.text("No samples yet", 10, 20, dim)
+		}
+		for i, s := range sl {
+			y := popup.h * i / len(sl)
+			ry := popup.h * (i + 1) / len(sl)
+
+			// Draw the core number and current usage.
+			var v float64
+			if len(s) > 0 {
+				v = s[len(s)-1]
 			}
-			//e := int(math.Round(se))
+			popup.text(fmt.Sprintf("%d %3.0f%%", i, v*100), 10, (y+ry)/2+4,
+				fg)
 
-			// Calculate the dot lenght, this is the length of the line divided
-			// by the length of the song.
-			sd, err := strconv.ParseFloat(sts["duration"], 32)
-			if err != nil {
-				log.Println(err)
-				return
+			// Draw the history.
+			sparkline(popup.img, image.Rect(70, y+3, popup.w-10, ry-3), s,
+				fg)
+			fill(popup.img, image.Rect(70, ry-3, popup.w-10, ry-2), dim)
+		}
+
+		// Redraw the popup.
+		popup.draw()
+	}
+
+	return nil
+}
+
+// networkPopup is a popup that lists the network interfaces with their state,
+// SSID and addresses. Below every interface the receive and transmit rates
+// are drawn as sparklines in the `rx` and `tx` colors, scaled to the highest
+// rate of that interface.
+func (bar *Bar) networkPopup(popup *Popup, p params) error {
+	bg := p.color("bg", popupBg)
+	fg := p.color("fg", popupFg)
+	dim := p.color("dim", popupDim)
+	rx := p.color("rx", xgraphics.BGRA{B: 228, G: 201, R: 169, A: 0xFF})
+	tx := p.color("tx", xgraphics.BGRA{B: 79, G: 96, R: 211, A: 0xFF})
+
+	popup.update = func() {
+		ns, ok := bar.getStore("network").(*netStats)
+		if !ok {
+			log.Println("popup \"network\": Requires a network block")
+			return
+		}
+
+		// Color the background.
+		popup.img.For(func(cx, cy int) xgraphics.BGRA {
+			return bg
+		})
+
+		il, rates, hm := ns.get()
+		if len(il) == 0 {
+			popup.text("No interfaces", 10, 20, dim)
+		}
+
+		// Draw the interfaces, as long as they fit.
+		y := 0
+		for _, i := range il {
+			h := 50 + len(i.addrs)*14
+			if y+h > popup.h {
+				break
 			}
-			d := 159.00 / sd
 
-			// Calculate elapsed line length.
-			e := int(math.Round(d*se)) + 10
+			// Draw the name, state and rates.
+			st := "down"
+			if i.up {
+				st = "up"
+			}
+			if i.ssid != "" {
+				st += ", " + i.ssid + " " + strconv.Itoa(i.signal) + "%"
+			}
+			r := rates[i.name]
+			rt := formatRate(r[0]) + "/" + formatRate(r[1])
+			rw := popup.drawer.MeasureString(rt).Ceil()
+			popup.text(rt, popup.w-10-rw, y+16, dim)
+			w := popup.text(i.name, 10, y+16, fg)
+			popup.text(popup.truncate(st, popup.w-30-w-rw, 'e'), 18+w, y+16,
+				dim)
+
+			// Draw the addresses.
+			ay := y + 16
+			for _, a := range i.addrs {
+				ay += 14
+				popup.text(popup.truncate(a, popup.w-20, 'e'), 10, ay, dim)
+			}
 
-			// Draw line.
-			popup.img.SubImage(image.Rect(10, 131, 10+159, 132)).(*xgraphics.
-				Image).For(func(x, y int) xgraphics.BGRA {
-				// Make the line look dashed.
-				if x%5 == 4 {
-					return xgraphics.BGRA{B: 238, G: 238, R: 238, A: 0xFF}
+			// Draw the receive and transmit rates, relative to the highest
+			// rate in the history.
+			var sl [2][]float64
+			var max float64
+			for j, s := range hm[i.name] {
+				if j > 1 {
+					break
 				}
-
-				if x < e {
-					return xgraphics.BGRA{B: 33, G: 27, R: 2, A: 0xFF}
+				sl[j] = s
+				for _, v := range s {
+					max = math.Max(max, v)
 				}
-				return xgraphics.BGRA{B: 211, G: 167, R: 114, A: 0xFF}
-			})
+			}
+			gr := image.Rect(10, ay+6, popup.w-10, y+h-6)
+			for j, c := range []xgraphics.BGRA{rx, tx} {
+				vl := make([]float64, len(sl[j]))
+				for k, v := range sl[j] {
+					if max > 0 {
+						vl[k] = v / max
+					}
+				}
+				sparkline(popup.img, gr, vl, c)
+			}
 
-			// Redraw the popup.
-			popup.draw()
-		},
-	})
+			// Draw a separator line.
+			y += h
+			fill(popup.img, image.Rect(0, y-1, popup.w, y), dim)
+		}
 
-	/*bar.popups.Set("clock", &Popup{
-		x: (bar.w / 2) - (178 / 2),
-		y: bar.h,
-		w: 178,
-		h: 129,
+		// Redraw the popup.
+		popup.draw()
+	}
 
-		update: func() {
-			popup := bar.popup("clock")
+	return nil
+}
 
-			// Color the background.
-			f, err := runtime.Open("/images/clock-popup-bg.png")
-			if err != nil {
-				log.Println(err)
-				return
+// volumePopup is a popup with a volume slider for every sink and stream, the
+// default sink is marked with an asterisk. Clicking a slider sets the volume,
+// scrolling changes it, and clicking the name of a sink or stream mutes it.
+func (bar *Bar) volumePopup(popup *Popup, p params) error {
+	bg := p.color("bg", popupBg)
+	fg := p.color("fg", popupFg)
+	dim := p.color("dim", popupDim)
+	track := p.color("track", xgraphics.BGRA{B: 204, G: 204, R: 204,
+		A: 0xFF})
+
+	// The number of clickable steps of a slider.
+	const steps = 20
+
+	popup.update = func() {
+		vc, ok := bar.getStore("volume").(*volumeControl)
+		if !ok {
+			log.Println("popup \"volume\": Requires a volume block")
+			return
+		}
+
+		// Color the background.
+		popup.img.For(func(cx, cy int) xgraphics.BGRA {
+			return bg
+		})
+		popup.regions = nil
+
+		cl := vc.get()
+		if len(cl) == 0 {
+			popup.text("No sinks", 10, 20, dim)
+		}
+
+		// Draw the channels, as long as they fit.
+		y := 0
+		for _, c := range cl {
+			const h = 36
+			if y+h > popup.h {
+				break
 			}
-			defer f.Close()
-			bg, _, err := image.Decode(f.(io.Reader))
-			if err != nil {
-				log.Println(err)
-				return
+			ch := " sink " + strconv.Itoa(int(c.index))
+			if c.kind == 'i' {
+				ch = " stream " + strconv.Itoa(int(c.index))
 			}
-			xgraphics.Blend(popup.img, xgraphics.NewConvert(X, bg), image.Point{
-				0, 0})
-
-			// Redraw the popup.
-			popup.draw()
 
-			// Set foreground color.
-			popup.drawer.Src = image.NewUniform(xgraphics.BGRA{B: 33, G: 27,
-				R: 2, A: 0xFF})
+			// Scroll to change the volume.
+			popup.regions = append(popup.regions, region{
+				rect: image.Rect(0, y, popup.w, y+h),
+				actions: map[xproto.Button]string{
+					4: "volume up" + ch,
+					5: "volume down" + ch,
+				},
+			})
 
-			// Create http client with a timeout.
-			c := &http.Client{Timeout: time.Duration(2 * time.Second)}
+			// Draw the volume and the name, the default sink is marked.
+			c1 := fg
+			if c.mute {
+				c1 = dim
+			}
+			vt := strconv.Itoa(int(math.Round(c.volume*100))) + "%"
+			if c.mute {
+				vt = "muted"
+			}
+			vw := popup.drawer.MeasureString(vt).Ceil()
+			popup.text(vt, popup.w-10-vw, y+16, dim)
+			name := c.name
+			if c.def {
+				name = "* " + name
+			}
+			nw := popup.text(popup.truncate(name, popup.w-30-vw, 'e'), 10,
+				y+16, c1)
+			popup.regions = append(popup.regions, region{
+				rect: image.Rect(10, y, 10+nw, y+20),
+				actions: map[xproto.Button]string{
+					1: "volume mute" + ch,
+				},
+			})
 
-			// Get weather information.
-			r, err := c.Get(
-				"https://api.buienradar.nl/data/public/2.0/jsonfeed")
-			if err != nil {
-				log.Println(err)
-				return
+			// Draw the slider, clicking a step sets the volume.
+			sr := image.Rect(10, y+24, popup.w-10, y+28)
+			fill(popup.img, sr, track)
+			hbar(popup.img, sr, c.volume/vc.max, c1)
+			for j := 0; j < steps; j++ {
+				x := sr.Min.X + sr.Dx()*j/steps
+				rx := sr.Min.X + sr.Dx()*(j+1)/steps
+				v := vc.max * 100 * float64(j+1) / steps
+				popup.regions = append(popup.regions, region{
+					rect: image.Rect(x, y+20, rx, y+h),
+					actions: map[xproto.Button]string{
+						1: "volume set " + strconv.Itoa(int(math.Round(
+							v))) + ch,
+					},
+				})
 			}
-			defer r.Body.Close()
-			jq := gojsonq.New().Reader(r.Body).From(
-				"actual.stationmeasurements.[0]").WhereEqual("stationid",
-				"6260")
 
-			// Draw weather information.
-			popup.drawer.Dot = fixed.P(11, 101)
-			popup.drawer.DrawString("Rainfall graph, it's " + fmt.Sprint(jq.
-				Find("feeltemperature")) + "°C.")
+			// Draw a separator line.
+			y += h
+			fill(popup.img, image.Rect(0, y-1, popup.w, y), dim)
+		}
 
-			// Redraw the popup.
-			popup.draw()
+		// Redraw the popup.
+		popup.draw()
+	}
 
-			// Set location.
-			lat := "52.0646"
-			lon := "5.2065"
+	return nil
+}
 
-			// Get rainfall information.
-			r, err = c.Get(
-				"https://gpsgadget.buienradar.nl/data/raintext?lat=" + lat +
-					"&lon=" + lon)
-			if err != nil {
-				log.Println(err)
-				return
+// diskPopup is a popup that draws a usage bar on a `track` for every mount,
+// with its device and file system type. Removable devices get an `[unmount]`
+// button.
+func (bar *Bar) diskPopup(popup *Popup, p params) error {
+	bg := p.color("bg", popupBg)
+	fg := p.color("fg", popupFg)
+	dim := p.color("dim", popupDim)
+	track := p.color("track", xgraphics.BGRA{B: 204, G: 204, R: 204,
+		A: 0xFF})
+
+	popup.update = func() {
+		ds, ok := bar.getStore("disk").(*diskStats)
+		if !ok {
+			log.Println("popup \"disk\": Requires a disk block")
+			return
+		}
+
+		// Color the background.
+		popup.img.For(func(cx, cy int) xgraphics.BGRA {
+			return bg
+		})
+		popup.regions = nil
+
+		ml := ds.get()
+		if len(ml) == 0 {
+			popup.text("No mounts", 10, 20, dim)
+		}
+
+		// Draw the mounts, as long as they fit.
+		y := 0
+		for _, m := range ml {
+			const h = 50
+			if y+h > popup.h {
+				break
 			}
-			defer r.Body.Close()
 
-			// Create rainfall tmp files.
-			td, err := ioutil.TempFile(os.TempDir(), "melonbar-rain-*.dat")
-			if err != nil {
-				log.Println(err)
-				return
+			// Draw the unmount button of removable devices.
+			bw := 0
+			if m.removable {
+				const l = "[unmount]"
+				bw = popup.drawer.MeasureString(l).Ceil() + 8
+				popup.text(l, popup.w-10-bw+8, y+16, fg)
+				popup.regions = append(popup.regions, region{
+					rect: image.Rect(popup.w-10-bw, y, popup.w, y+22),
+					actions: map[xproto.Button]string{
+						1: ds.unmountAction(m),
+					},
+				})
 			}
-			defer os.Remove(td.Name())
-			ti, err := ioutil.TempFile(os.TempDir(), "melonbar-rain-*.png")
-			if err != nil {
-				log.Println(err)
-				return
-			}
-			defer os.Remove(ti.Name())
 
-			// Compose rainfall data tmp file contents.
-			var d []byte
-			s := bufio.NewScanner(r.Body)
-			for s.Scan() {
-				d = append(d, bytes.Split(s.Bytes(), []byte("|"))[0]...)
-				d = append(d, []byte("\n")...)
+			// Draw the mount point, device and usage.
+			popup.text(popup.truncate(m.dir, popup.w-20-bw, 'e'), 10, y+16, fg)
+			popup.text(popup.truncate(m.device+" "+m.fstype, popup.w-20, 'e'),
+				10, y+30, dim)
+			u := formatKB(m.used/1024) + " / " + formatKB((m.used+m.avail)/
+				1024) + " " + percent(m.fraction())
+			uw := popup.drawer.MeasureString(u).Ceil()
+			popup.text(u, popup.w-10-uw, y+30, dim)
+
+			// Draw the usage bar.
+			br := image.Rect(10, y+38, popup.w-10, y+42)
+			fill(popup.img, br, track)
+			hbar(popup.img, br, m.fraction(), fg)
+
+			// Draw a separator line.
+			y += h
+			fill(popup.img, image.Rect(0, y-1, popup.w, y), dim)
+		}
+
+		// Redraw the popup.
+		popup.draw()
+	}
+
+	return nil
+}
+
+// sensorsPopup is a popup that draws the history of every sensor as a
+// sparkline. Temperatures are scaled to their critical temperature, or to
+// `max` degrees if the chip doesn't report one, and fan speeds to the fastest
+// speed in their history.
+func (bar *Bar) sensorsPopup(popup *Popup, p params) error {
+	bg := p.color("bg", popupBg)
+	fg := p.color("fg", popupFg)
+	dim := p.color("dim", popupDim)
+	max := p.float("max", 100)
+
+	popup.update = func() {
+		ss, ok := bar.getStore("sensors").(*sensorStats)
+		if !ok {
+			log.Println("popup \"sensors\": Requires a sensors block")
+			return
+		}
+
+		// Color the background.
+		popup.img.For(func(cx, cy int) xgraphics.BGRA {
+			return bg
+		})
+
+		sl, hm := ss.get()
+		if len(sl) == 0 {
+			popup.text("No sensors", 10, 20, dim)
+		}
+
+		// Draw the sensors, as long as they fit.
+		y := 0
+		for _, s := range sl {
+			const h = 50
+			if y+h > popup.h {
+				break
 			}
 
-			// Write rainfall data tmp file.
-			if _, err = td.Write(d); err != nil {
-				log.Println(err)
-				return
+			// Draw the name and current reading.
+			v := strconv.Itoa(int(math.Round(s.value))) + "°C"
+			if s.kind == 'f' {
+				v = strconv.Itoa(int(s.value)) + " RPM"
 			}
-			if err := td.Close(); err != nil {
-				log.Println(err)
-				return
+			vw := popup.drawer.MeasureString(v).Ceil()
+			popup.text(v, popup.w-10-vw, y+16, dim)
+			popup.text(popup.truncate(s.name(), popup.w-30-vw, 'e'), 10,
+				y+16, fg)
+
+			// Draw the history, relative to the critical temperature or the
+			// fastest fan speed.
+			m := max
+			if s.kind == 't' && s.crit > 0 {
+				m = s.crit
 			}
-
-			// Create rainfall graph.
-			cmd := exec.Command("gnuplot", "-e", `
-				set terminal png transparent size 205,107;
-				set output '`+ti.Name()+`';
-				set yrange [0:255];
-				set noborder;
-				set nolabel;
-				set nokey;
-				set notics;
-				set notitle;
-				plot '`+td.Name()+`' smooth csplines w filledcu x1 fc rgb '#72A7D3', '`+td.Name()+`' smooth csplines w line lc rgb '#5394C9';
-			`)
-			if err := cmd.Run(); err != nil {
-				log.Println(err)
-				return
+			if s.kind == 'f' {
+				m = 0
+				for _, v := range hm[s.name()] {
+					m = math.Max(m, v)
+				}
 			}
-
-			// Draw rainfall graph.
-			img, _, err := image.Decode(ti)
-			if err != nil {
-				log.Println(err)
-				return
+			vl := make([]float64, len(hm[s.name()]))
+			for i, v := range hm[s.name()] {
+				if m > 0 {
+					vl[i] = v / m
+				}
 			}
-			xgraphics.Blend(popup.img, xgraphics.NewConvert(X, img), image.
-				Point{11, 2})
+			sparkline(popup.img, image.Rect(10, y+22, popup.w-10, y+h-6), vl,
+				fg)
 
-			// Redraw the popup.
-			popup.draw()
-		},
-	})*/
+			// Draw a separator line.
+			y += h
+			fill(popup.img, image.Rect(0, y-1, popup.w, y), dim)
+		}
+
+		// Redraw the popup.
+		popup.draw()
+	}
+
+	return nil
 }
+
+/*bar.popups.Set("clock", &Popup{
+	x: (bar.w / 2) - (178 / 2),
+	y: bar.h,
+	w: 178,
+	h: 129,
+
+	update: func() {
+		popup := bar.popup("clock")
+
+		// Color the background.
+		f, err := runtime.Open("/images/clock-popup-bg.png")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer f.Close()
+		bg, _, err := image.Decode(f.(io.Reader))
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		xgraphics.Blend(popup.img, xgraphics.NewConvert(X, bg), image.Point{
+			0, 0})
+
+		// Redraw the popup.
+		popup.draw()
+
+		// Set foreground color.
+		popup.drawer.Src = image.NewUniform(xgraphics.BGRA{B: 33, G: 27,
+			R: 2, A: 0xFF})
+
+		// Create http client with a timeout.
+		c := &http.Client{Timeout: time.Duration(2 * time.Second)}
+
+		// Get weather information.
+		r, err := c.Get(
+			"https://api.buienradar.nl/data/public/2.0/jsonfeed")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer r.Body.Close()
+		jq := gojsonq.New().Reader(r.Body).From(
+			"actual.stationmeasurements.[0]").WhereEqual("stationid",
+			"6260")
+
+		// Draw weather information.
+		popup.drawer.Dot = fixed.P(11, 101)
+		popup.drawer.DrawString("Rainfall graph, it's " + fmt.Sprint(jq.
+			Find("feeltemperature")) + "°C.")
+
+		// Redraw the popup.
+		popup.draw()
+
+		// Set location.
+		lat := "52.0646"
+		lon := "5.2065"
+
+		// Get rainfall information.
+		r, err = c.Get(
+			"https://gpsgadget.buienradar.nl/data/raintext?lat=" + lat +
+				"&lon=" + lon)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer r.Body.Close()
+
+		// Create rainfall tmp files.
+		td, err := ioutil.TempFile(os.TempDir(), "melonbar-rain-*.dat")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer os.Remove(td.Name())
+		ti, err := ioutil.TempFile(os.TempDir(), "melonbar-rain-*.png")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		defer os.Remove(ti.Name())
+
+		// Compose rainfall data tmp file contents.
+		var d []byte
+		s := bufio.NewScanner(r.Body)
+		for s.Scan() {
+			d = append(d, bytes.Split(s.Bytes(), []byte("|"))[0]...)
+			d = append(d, []byte("\n")...)
+		}
+
+		// Write rainfall data tmp file.
+		if _, err = td.Write(d); err != nil {
+			log.Println(err)
+			return
+		}
+		if err := td.Close(); err != nil {
+			log.Println(err)
+			return
+		}
+
+		// Create rainfall graph.
+		cmd := exec.Command("gnuplot", "-e", `
+			set terminal png transparent size 205,107;
+			set output '`+ti.Name()+`';
+			set yrange [0:255];
+			set noborder;
+			set nolabel;
+			set nokey;
+			set notics;
+			set notitle;
+			plot '`+td.Name()+`' smooth csplines w filledcu x1 fc rgb '#72A7D3', '`+td.Name()+`' smooth csplines w line lc rgb '#5394C9';
+		`)
+		if err := cmd.Run(); err != nil {
+			log.Println(err)
+			return
+		}
+
+		// Draw rainfall graph.
+		img, _, err := image.Decode(ti)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		xgraphics.Blend(popup.img, xgraphics.NewConvert(X, img), image.
+			Point{11, 2})
+
+		// Redraw the popup.
+		popup.draw()
+	},
+})*/
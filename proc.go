@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// cpuTimes is a struct with the time a CPU spent idle and in total, in clock
+// ticks since boot.
+type cpuTimes struct {
+	idle, total uint64
+}
+
+// readCPU reads the CPU times from `/proc/stat` below `root`. The first
+// element is the total of all cores, the other elements are the cores.
+func readCPU(root string) ([]cpuTimes, error) {
+	f, err := os.Open(filepath.Join(root, "stat"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var tl []cpuTimes
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fl := strings.Fields(s.Text())
+		if len(fl) < 5 || !strings.HasPrefix(fl[0], "cpu") {
+			continue
+		}
+
+		// The fields are user, nice, system, idle, iowait, irq, softirq,
+		// steal, guest and guest_nice. Guest time is already included in
+		// user time.
+		var t cpuTimes
+		for i, v := range fl[1:] {
+			if i >= 8 {
+				break
+			}
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parse %q: Not a valid CPU time", v)
+			}
+			t.total += n
+			if i == 3 || i == 4 {
+				t.idle += n
+			}
+		}
+		tl = append(tl, t)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	if len(tl) == 0 {
+		return nil, fmt.Errorf("cpu %q: No CPU times found", root)
+	}
+
+	return tl, nil
+}
+
+// usage returns the fraction of time the CPU was busy between the previous
+// and the current times. The idle time includes iowait, which can go
+// backwards, so the result is clamped between zero and one.
+func (t cpuTimes) usage(prev cpuTimes) float64 {
+	if t.total <= prev.total {
+		return 0
+	}
+	if t.idle < prev.idle {
+		return 1
+	}
+	return math.Max(0, 1-float64(t.idle-prev.idle)/float64(t.total-prev.
+		total))
+}
+
+// readMeminfo reads `/proc/meminfo` below `root`, the values are in kB.
+func readMeminfo(root string) (map[string]uint64, error) {
+	f, err := os.Open(filepath.Join(root, "meminfo"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := make(map[string]uint64)
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fl := strings.Fields(s.Text())
+		if len(fl) < 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(fl[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		m[strings.TrimSuffix(fl[0], ":")] = n
+	}
+
+	return m, s.Err()
+}
+
+// readLoad reads the 1, 5 and 15 minute load averages from `/proc/loadavg`
+// below `root`.
+func readLoad(root string) ([3]float64, error) {
+	var l [3]float64
+
+	b, err := os.ReadFile(filepath.Join(root, "loadavg"))
+	if err != nil {
+		return l, err
+	}
+	fl := strings.Fields(string(b))
+	if len(fl) < 3 {
+		return l, fmt.Errorf("parse %q: Not a valid load average", string(b))
+	}
+	for i := range l {
+		if l[i], err = strconv.ParseFloat(fl[i], 64); err != nil {
+			return l, fmt.Errorf("parse %q: Not a valid load average", fl[i])
+		}
+	}
+
+	return l, nil
+}
+
+// history is a struct that keeps the last values of one or more series, for
+// example the usage of each CPU core.
+type history struct {
+	sync.Mutex
+
+	// The values of each series, the newest value is last.
+	series [][]float64
+
+	// The maximum number of values to keep.
+	max int
+}
+
+// add adds the values to the series, one value per series.
+func (h *history) add(vl ...float64) {
+	h.Lock()
+	defer h.Unlock()
+
+	for len(h.series) < len(vl) {
+		h.series = append(h.series, nil)
+	}
+	for i, v := range vl {
+		h.series[i] = append(h.series[i], v)
+		if len(h.series[i]) > h.max {
+			h.series[i] = h.series[i][len(h.series[i])-h.max:]
+		}
+	}
+}
+
+// get returns a copy of the series.
+func (h *history) get() [][]float64 {
+	h.Lock()
+	defer h.Unlock()
+
+	sl := make([][]float64, len(h.series))
+	for i, s := range h.series {
+		sl[i] = append([]float64(nil), s...)
+	}
+	return sl
+}
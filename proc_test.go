@@ -0,0 +1,82 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadCPU(t *testing.T) {
+	tl, err := readCPU("testdata/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Guest time isn't counted twice, and iowait counts as idle.
+	want := []cpuTimes{{500, 1000}, {250, 500}, {250, 500}}
+	if !reflect.DeepEqual(tl, want) {
+		t.Errorf("readCPU = %v, want %v", tl, want)
+	}
+
+	if _, err := readCPU("testdata"); err == nil {
+		t.Error("readCPU: Expected an error for a missing file")
+	}
+}
+
+func TestCPUUsage(t *testing.T) {
+	for _, tc := range []struct {
+		prev, cur cpuTimes
+		want      float64
+	}{
+		{cpuTimes{500, 1000}, cpuTimes{600, 1400}, 0.75},
+		{cpuTimes{500, 1000}, cpuTimes{900, 1400}, 0},
+		{cpuTimes{500, 1000}, cpuTimes{500, 1000}, 0},
+		{cpuTimes{500, 1000}, cpuTimes{490, 1400}, 1},
+		{cpuTimes{500, 1000}, cpuTimes{1000, 1400}, 0},
+	} {
+		if u := tc.cur.usage(tc.prev); u != tc.want {
+			t.Errorf("%v.usage(%v) = %v, want %v", tc.cur, tc.prev, u,
+				tc.want)
+		}
+	}
+}
+
+func TestReadMeminfo(t *testing.T) {
+	m, err := readMeminfo("testdata/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]uint64{
+		"MemTotal":        16000000,
+		"MemAvailable":    8000000,
+		"SwapFree":        3000000,
+		"HugePages_Total": 0,
+	} {
+		if m[k] != v {
+			t.Errorf("readMeminfo: %s = %d, want %d", k, m[k], v)
+		}
+	}
+}
+
+func TestReadLoad(t *testing.T) {
+	l, err := readLoad("testdata/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [3]float64{0.52, 1.25, 2}; l != want {
+		t.Errorf("readLoad = %v, want %v", l, want)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	h := &history{max: 2}
+	h.add(1, 2)
+	h.add(3)
+	h.add(5, 6)
+
+	// The second series missed a value, and only the last two values are
+	// kept.
+	want := [][]float64{{3, 5}, {2, 6}}
+	if sl := h.get(); !reflect.DeepEqual(sl, want) {
+		t.Errorf("history.get = %v, want %v", sl, want)
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// marquee is a struct with information about the scrolling text of a block.
+type marquee struct {
+	// The speed in pixels per second, and the time to pause at both ends.
+	speed int
+	pause time.Duration
+
+	// The current offset of the text in pixels, the text the offset belongs
+	// to, and the time until which scrolling is paused.
+	off   int
+	txt   string
+	until time.Time
+
+	// The function that stops the ticker, this is nil if the ticker isn't
+	// running.
+	stop context.CancelFunc
+}
+
+// scrolling checks if the text of the block, with a width of `tw`, needs to
+// scroll. It starts the ticker that scrolls the text if required, and stops
+// it if not. The text of a hidden bar doesn't scroll. The block should be
+// locked.
+func (bar *Bar) scrolling(block *Block, tw int) bool {
+	m := block.scroll
+	if m == nil {
+		return false
+	}
+
+	// Stop scrolling if the text fits or the bar is hidden, the bar is
+	// redrawn once it is shown again.
+	if bar.hidden || tw <= block.w-(block.pad*2) {
+		m.halt()
+		m.off = 0
+		return false
+	}
+
+	// Start at the beginning if the text changed.
+	if m.txt != block.txt {
+		m.txt = block.txt
+		m.off = 0
+		m.until = time.Now().Add(m.pause)
+	}
+
+	// Start the ticker.
+	if m.stop == nil {
+		var ctx context.Context
+		ctx, m.stop = context.WithCancel(block.ctx)
+
+		go func() {
+			t := time.NewTicker(time.Second / time.Duration(m.speed))
+			defer t.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-t.C:
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case bar.scroll <- block:
+				}
+			}
+		}()
+	}
+
+	return true
+}
+
+// step scrolls the text of the block by one pixel, and redraws it. This
+// should be called from the goroutine that draws the bar.
+func (bar *Bar) step(block *Block) error {
+	m := block.scroll
+	if m.stop == nil || time.Now().Before(m.until) {
+		return nil
+	}
+
+	// Jump back to the beginning once the end of the text is visible, and
+	// pause at both ends.
+	block.mu.Lock()
+	max := bar.textWidth(parseMarkup(block.txt, block.fg, block.bg)) - (block.
+		w - (block.pad * 2))
+	block.mu.Unlock()
+	if m.off >= max {
+		m.off = 0
+		m.until = time.Now().Add(m.pause)
+	} else {
+		m.off++
+		if m.off >= max {
+			m.until = time.Now().Add(m.pause)
+		}
+	}
+
+	return bar.draw(block)
+}
+
+// halt stops the ticker.
+func (m *marquee) halt() {
+	if m == nil || m.stop == nil {
+		return
+	}
+
+	m.stop()
+	m.stop = nil
+}
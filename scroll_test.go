@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestScrolling(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	bar := testBar(200)
+	block := &Block{ctx: ctx, txt: "scrolling text", w: 50, pad: 5,
+		scroll: &marquee{speed: 30, pause: time.Second}}
+
+	for _, tc := range []struct {
+		hidden bool
+		tw     int
+		want   bool
+	}{
+		{false, 40, false},
+		{false, 41, true},
+		{false, 41, true},
+		{true, 41, false},
+		{false, 98, true},
+		{false, 20, false},
+	} {
+		bar.hidden = tc.hidden
+		if ok := bar.scrolling(block, tc.tw); ok != tc.want {
+			t.Errorf("scrolling(%d) with hidden %t = %t, want %t", tc.tw,
+				tc.hidden, ok, tc.want)
+		}
+		if run := block.scroll.stop != nil; run != tc.want {
+			t.Errorf("scrolling(%d) with hidden %t: Ticker running is %t, "+
+				"want %t", tc.tw, tc.hidden, run, tc.want)
+		}
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"sync"
+
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/xevent"
+	"github.com/BurntSushi/xgbutil/xprop"
+	"github.com/BurntSushi/xgbutil/xwindow"
+)
+
+// trayLock is held by the system tray that owns the tray selection. Only one
+// tray can exist at a time, a new tray waits until the old tray is gone.
+var trayLock = make(chan struct{}, 1)
+
+// tray is a struct with information about an XEmbed system tray, see
+// https://specifications.freedesktop.org/systemtray-spec/.
+type tray struct {
+	sync.Mutex
+
+	// The window that owns the system tray selection.
+	owner xproto.Window
+
+	// The embedded icon windows, in the order they appeared, and if they
+	// want to be mapped or not.
+	icons  []xproto.Window
+	mapped map[xproto.Window]bool
+
+	// The width and height of the icons, zero for the height of the bar, and
+	// the space between them.
+	size, gap int
+}
+
+// The XEmbed and system tray opcodes we use.
+const (
+	trayRequestDock       = 0
+	xembedEmbeddedNotify  = 0
+	xembedMappedFlag      = 1
+	xembedProtocolVersion = 0
+)
+
+// claim creates the selection owner window, and claims the system tray
+// selection of the default screen. The function `f` is executed every time
+// an icon appears or disappears, and `lost` when another tray takes over the
+// selection.
+func (t *tray) claim(bar *Bar, f, lost func()) error {
+	// Create an invisible window that owns the selection.
+	win, err := xwindow.Generate(X)
+	if err != nil {
+		return err
+	}
+	win.Create(X.RootWin(), -1, -1, 1, 1, xproto.CwOverrideRedirect, 1)
+	t.owner = win.Id
+
+	// Set the orientation of the tray to horizontal.
+	if err := xprop.ChangeProp32(X, t.owner, "_NET_SYSTEM_TRAY_ORIENTATION",
+		"CARDINAL", 0); err != nil {
+		return err
+	}
+
+	// Claim the selection, and check if we got it.
+	sel, err := xprop.Atm(X, fmt.Sprintf("_NET_SYSTEM_TRAY_S%d", X.Conn().
+		DefaultScreen))
+	if err != nil {
+		return err
+	}
+	xproto.SetSelectionOwner(X.Conn(), t.owner, sel, xproto.TimeCurrentTime)
+	so, err := xproto.GetSelectionOwner(X.Conn(), sel).Reply()
+	if err != nil {
+		return err
+	}
+	if so.Owner != t.owner {
+		return fmt.Errorf("tray: Selection is owned by another tray")
+	}
+
+	// Listen for dock requests, and for other trays taking over.
+	op, err := xprop.Atm(X, "_NET_SYSTEM_TRAY_OPCODE")
+	if err != nil {
+		return err
+	}
+	xevent.ClientMessageFun(func(_ *xgbutil.XUtil, ev xevent.
+		ClientMessageEvent) {
+		if ev.Type != op || ev.Format != 32 || ev.Data.Data32[1] !=
+			trayRequestDock {
+			return
+		}
+		if t.dock(bar, xproto.Window(ev.Data.Data32[2]), f) {
+			f()
+		}
+	}).Connect(X, t.owner)
+	xevent.SelectionClearFun(func(_ *xgbutil.XUtil, ev xevent.
+		SelectionClearEvent) {
+		lost()
+	}).Connect(X, t.owner)
+
+	// Announce the tray to the icons that are waiting for one.
+	mgr, err := xprop.Atm(X, "MANAGER")
+	if err != nil {
+		return err
+	}
+	cm, err := xevent.NewClientMessage(32, X.RootWin(), mgr,
+		int(xproto.TimeCurrentTime), int(sel), int(t.owner))
+	if err != nil {
+		return err
+	}
+	return xevent.SendRootEvent(X, cm, xproto.EventMaskStructureNotify)
+}
+
+// dock embeds the icon window into the bar window. It returns false if the
+// icon is already docked.
+func (t *tray) dock(bar *Bar, id xproto.Window, f func()) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if _, ok := t.mapped[id]; ok {
+		return false
+	}
+
+	// Make sure the icon survives if we crash, listen for changes and let it
+	// use the background of the bar.
+	xproto.ChangeSaveSet(X.Conn(), xproto.SetModeInsert, id)
+	if err := xwindow.New(X, id).Listen(xproto.EventMaskStructureNotify |
+		xproto.EventMaskPropertyChange); err != nil {
+		return false
+	}
+	xproto.ChangeWindowAttributes(X.Conn(), id, xproto.CwBackPixmap,
+		[]uint32{xproto.BackPixmapParentRelative})
+
+	// Reparent the icon and tell it it has been embedded.
+	xproto.ReparentWindow(X.Conn(), id, bar.win.Id, 0, 0)
+	if xe, err := xprop.Atm(X, "_XEMBED"); err == nil {
+		if cm, err := xevent.NewClientMessage(32, id, xe, int(xproto.
+			TimeCurrentTime), xembedEmbeddedNotify, 0, int(bar.win.Id),
+			xembedProtocolVersion); err == nil {
+			xproto.SendEvent(X.Conn(), false, id, xproto.EventMaskNoEvent,
+				string(cm.Bytes()))
+		}
+	}
+
+	t.icons = append(t.icons, id)
+	t.mapped[id] = xembedMapped(id)
+
+	// Remove the icon once it is destroyed or reparented by someone else,
+	// and map or unmap it when it asks for it.
+	xevent.DestroyNotifyFun(func(_ *xgbutil.XUtil, ev xevent.
+		DestroyNotifyEvent) {
+		t.remove(id)
+		f()
+	}).Connect(X, id)
+	xevent.ReparentNotifyFun(func(_ *xgbutil.XUtil, ev xevent.
+		ReparentNotifyEvent) {
+		if ev.Parent != bar.win.Id {
+			t.remove(id)
+			f()
+		}
+	}).Connect(X, id)
+	xevent.PropertyNotifyFun(func(_ *xgbutil.XUtil, ev xevent.
+		PropertyNotifyEvent) {
+		if n, err := xprop.AtomName(X, ev.Atom); err != nil ||
+			n != "_XEMBED_INFO" {
+			return
+		}
+		t.Lock()
+		t.mapped[id] = xembedMapped(id)
+		t.Unlock()
+		f()
+	}).Connect(X, id)
+
+	return true
+}
+
+// remove forgets the icon window.
+func (t *tray) remove(id xproto.Window) {
+	t.Lock()
+	defer t.Unlock()
+
+	xevent.Detach(X, id)
+	delete(t.mapped, id)
+	for i, icon := range t.icons {
+		if icon == id {
+			t.icons = append(t.icons[:i], t.icons[i+1:]...)
+			break
+		}
+	}
+}
+
+// release gives back all icons to the root window, and releases the
+// selection by destroying the selection owner window.
+func (t *tray) release() {
+	t.Lock()
+	defer t.Unlock()
+
+	for _, id := range t.icons {
+		xevent.Detach(X, id)
+		xproto.UnmapWindow(X.Conn(), id)
+		xproto.ReparentWindow(X.Conn(), id, X.RootWin(), 0, 0)
+		xproto.ChangeSaveSet(X.Conn(), xproto.SetModeDelete, id)
+	}
+	t.icons = nil
+	t.mapped = make(map[xproto.Window]bool)
+
+	if t.owner != 0 {
+		xevent.Detach(X, t.owner)
+		xproto.DestroyWindow(X.Conn(), t.owner)
+		t.owner = 0
+	}
+}
+
+// iconSize returns the size of the icons in a bar with height `h`.
+func (t *tray) iconSize(h int) int {
+	if t.size > 0 {
+		return t.size
+	}
+	return h
+}
+
+// width returns the width of the mapped icons in a bar with height `h`.
+func (t *tray) width(h int) int {
+	t.Lock()
+	defer t.Unlock()
+
+	var w int
+	for _, id := range t.icons {
+		if t.mapped[id] {
+			w += t.iconSize(h) + t.gap
+		}
+	}
+	if w > 0 {
+		w -= t.gap
+	}
+	return w
+}
+
+// place moves the mapped icons to the given rectangle, and unmaps the other
+// icons.
+func (t *tray) place(r image.Rectangle) {
+	t.Lock()
+	defer t.Unlock()
+
+	size := t.iconSize(r.Dy())
+	x := r.Min.X
+	y := r.Min.Y + (r.Dy()-size)/2
+	for _, id := range t.icons {
+		if !t.mapped[id] || size <= 0 || x+size > r.Max.X {
+			xproto.UnmapWindow(X.Conn(), id)
+			continue
+		}
+
+		xproto.ConfigureWindow(X.Conn(), id, xproto.ConfigWindowX|xproto.
+			ConfigWindowY|xproto.ConfigWindowWidth|xproto.ConfigWindowHeight,
+			[]uint32{uint32(x), uint32(y), uint32(size), uint32(size)})
+		xproto.MapWindow(X.Conn(), id)
+
+		// Repaint the icon, its background changed.
+		xproto.ClearArea(X.Conn(), true, id, 0, 0, 0, 0)
+
+		x += size + t.gap
+	}
+}
+
+// run claims the system tray selection, and keeps it until `ctx` is done or
+// another tray takes over. The function `f` is executed every time an icon
+// appears or disappears.
+func (t *tray) run(ctx context.Context, bar *Bar, f func()) error {
+	select {
+	case trayLock <- struct{}{}:
+	case <-ctx.Done():
+		return nil
+	}
+	defer func() {
+		<-trayLock
+	}()
+
+	tctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	t.Lock()
+	t.mapped = make(map[xproto.Window]bool)
+	t.Unlock()
+	defer t.release()
+	if err := t.claim(bar, f, cancel); err != nil {
+		return err
+	}
+
+	<-tctx.Done()
+	return nil
+}
+
+// xembedMapped checks if the icon window wants to be mapped, according to
+// its `_XEMBED_INFO` property. Icons without the property are mapped.
+func xembedMapped(id xproto.Window) bool {
+	info, err := xprop.PropValNums(xprop.GetProperty(X, id, "_XEMBED_INFO"))
+	if err != nil || len(info) < 2 {
+		return true
+	}
+	return info[1]&xembedMappedFlag != 0
+}
@@ -0,0 +1,68 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"syscall"
+)
+
+// watchUevents returns a channel that receives a value every time the kernel
+// sends a uevent for a device of the given subsystem, until `ctx` is done.
+// The channel is never written to if the netlink socket can't be opened.
+func watchUevents(ctx context.Context, subsystem string) <-chan struct{} {
+	c := make(chan struct{}, 1)
+
+	// Open a netlink socket that receives kernel uevents. The socket is non
+	// blocking so that closing the file interrupts reading from it.
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_DGRAM|syscall.
+		SOCK_CLOEXEC|syscall.SOCK_NONBLOCK, syscall.NETLINK_KOBJECT_UEVENT)
+	if err != nil {
+		log.Println(err)
+		return c
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{
+		Family: syscall.AF_NETLINK,
+		Groups: 1,
+	}); err != nil {
+		log.Println(err)
+		syscall.Close(fd)
+		return c
+	}
+	f := os.NewFile(uintptr(fd), "uevent")
+
+	go func() {
+		<-ctx.Done()
+		f.Close()
+	}()
+
+	go func() {
+		// A uevent is a list of null separated `KEY=value` pairs.
+		key := []byte("SUBSYSTEM=" + subsystem)
+		b := make([]byte, 8192)
+		for {
+			n, err := f.Read(b)
+			if err != nil {
+				return
+			}
+
+			for _, kv := range bytes.Split(b[:n], []byte{0}) {
+				if !bytes.Equal(kv, key) {
+					continue
+				}
+
+				select {
+				case c <- struct{}{}:
+				default:
+				}
+				break
+			}
+		}
+	}()
+
+	return c
+}
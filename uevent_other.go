@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"context"
+)
+
+// watchUevents returns a channel that is never written to, uevents are only
+// sent by Linux.
+func watchUevents(ctx context.Context, subsystem string) <-chan struct{} {
+	return make(chan struct{}, 1)
+}
@@ -1,9 +1,221 @@
 package main
 
-// TODO: Instead of doing this using rune-count, do this using pixel-count.
-func trim(txt string, l int) string {
-	if len(txt) > l {
-		return txt[0:l] + "..."
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/xgbutil/ewmh"
+	"github.com/BurntSushi/xgbutil/xgraphics"
+	"golang.org/x/image/draw"
+	"golang.org/x/image/font"
+)
+
+// formatKB formats a size in kB using the largest unit that keeps it above
+// one, for example `1.5G`.
+func formatKB(n uint64) string {
+	v := float64(n)
+	for _, u := range []string{"K", "M", "G"} {
+		if v < 1024 {
+			return fmt.Sprintf("%.1f%s", v, u)
+		}
+		v /= 1024
+	}
+	return fmt.Sprintf("%.1fT", v)
+}
+
+// sparkline draws the values as a sparkline inside the rectangle, one pixel
+// per value with the newest value on the right. Values are between zero and
+// one.
+func sparkline(img *xgraphics.Image, r image.Rectangle, vl []float64,
+	c xgraphics.BGRA) {
+	if len(vl) > r.Dx() {
+		vl = vl[len(vl)-r.Dx():]
+	}
+
+	x := r.Max.X - len(vl)
+	for _, v := range vl {
+		h := int(math.Round(clamp(v) * float64(r.Dy())))
+		if h == 0 && v > 0 {
+			h = 1
+		}
+		fill(img, image.Rect(x, r.Max.Y-h, x+1, r.Max.Y), c)
+		x++
+	}
+}
+
+// hbar draws the value as a horizontal bar inside the rectangle, the value is
+// between zero and one.
+func hbar(img *xgraphics.Image, r image.Rectangle, v float64,
+	c xgraphics.BGRA) {
+	w := int(math.Round(clamp(v) * float64(r.Dx())))
+	fill(img, image.Rect(r.Min.X, r.Min.Y, r.Min.X+w, r.Max.Y), c)
+}
+
+// clamp limits the value to the range between zero and one.
+func clamp(v float64) float64 {
+	return math.Max(0, math.Min(1, v))
+}
+
+// bestIcon returns the valid EWMH icon whose largest side is closest to
+// `size`, preferring the larger icon if two are equally close. It returns nil
+// if there are no valid icons.
+func bestIcon(il []ewmh.WmIcon, size int) *ewmh.WmIcon {
+	var best *ewmh.WmIcon
+	var bs, bd int
+	for i, ic := range il {
+		if ic.Width == 0 || ic.Height == 0 || len(ic.Data) < int(ic.Width*ic.
+			Height) {
+			continue
+		}
+
+		// Calculate the distance to the requested size.
+		s := int(ic.Width)
+		if ic.Height > ic.Width {
+			s = int(ic.Height)
+		}
+		d := s - size
+		if d < 0 {
+			d = -d
+		}
+
+		if best == nil || d < bd || (d == bd && s > bs) {
+			best = &il[i]
+			bs, bd = s, d
+		}
+	}
+	return best
+}
+
+// scaleIcon picks the EWMH icon that fits `size` best, and scales it down or
+// up so that it fits a square of `size` pixels while keeping its aspect ratio.
+// It returns nil if there are no valid icons.
+func scaleIcon(il []ewmh.WmIcon, size int) image.Image {
+	ic := bestIcon(il, size)
+	if ic == nil {
+		return nil
+	}
+
+	// Convert the ARGB icon data.
+	src := image.NewNRGBA(image.Rect(0, 0, int(ic.Width), int(ic.Height)))
+	for i, argb := range ic.Data[:ic.Width*ic.Height] {
+		copy(src.Pix[i*4:], []uint8{uint8(argb >> 16), uint8(argb >> 8),
+			uint8(argb), uint8(argb >> 24)})
+	}
+
+	// Scale the icon.
+	w, h := size, size
+	if ic.Width > ic.Height {
+		h = size * int(ic.Height) / int(ic.Width)
+	} else {
+		w = size * int(ic.Width) / int(ic.Height)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+
+	return dst
+}
+
+// fill colors the given rectangle of an image.
+func fill(img *xgraphics.Image, r image.Rectangle, c xgraphics.BGRA) {
+	sub, ok := img.SubImage(r).(*xgraphics.Image)
+	if !ok {
+		return
+	}
+	sub.For(func(x, y int) xgraphics.BGRA {
+		return c
+	})
+}
+
+// truncate shortens the text so that it fits inside `w` pixels when drawn
+// with face `f`, replacing the removed part with an ellipsis. The ellipsis
+// can be placed at the start (`s`), the middle (`m`) or the end (`e`) of the
+// text. The text is only cut between graphemes.
+func truncate(f font.Face, txt string, w int, mode rune) string {
+	if font.MeasureString(f, txt).Ceil() <= w {
+		return txt
+	}
+
+	// Calculate the space left for the text.
+	const ell = "..."
+	w -= font.MeasureString(f, ell).Ceil()
+	if w <= 0 {
+		return ell
+	}
+
+	// Function that takes graphemes from the given slice, as long as they fit
+	// in `w` pixels.
+	gl := graphemes(txt)
+	take := func(gl []string, w int) int {
+		var n int
+		for i, g := range gl {
+			n += font.MeasureString(f, g).Ceil()
+			if n > w {
+				return i
+			}
+		}
+		return len(gl)
+	}
+
+	// Function that reverses a slice of graphemes.
+	rev := func(gl []string) []string {
+		rl := make([]string, len(gl))
+		for i, g := range gl {
+			rl[len(gl)-1-i] = g
+		}
+		return rl
+	}
+
+	switch mode {
+	case 's':
+		n := take(rev(gl), w)
+		return ell + strings.Join(gl[len(gl)-n:], "")
+	case 'm':
+		l := take(gl, w/2)
+		used := font.MeasureString(f, strings.Join(gl[:l], "")).Ceil()
+		r := take(rev(gl[l:]), w-used)
+		return strings.Join(gl[:l], "") + ell + strings.Join(gl[len(gl)-r:], "")
+	default:
+		n := take(gl, w)
+		return strings.Join(gl[:n], "") + ell
+	}
+}
+
+// graphemes splits text into graphemes. This is an approximation that keeps
+// combining marks, variation selectors and zero width joiner sequences
+// together with the rune they belong to.
+func graphemes(txt string) []string {
+	var gl []string
+	join := false
+	for len(txt) > 0 {
+		r, n := utf8.DecodeRuneInString(txt)
+
+		ext := unicode.In(r, unicode.Mn, unicode.Me, unicode.
+			Variation_Selector) || r == '\u200d'
+		if len(gl) > 0 && (ext || join) {
+			gl[len(gl)-1] += txt[:n]
+		} else {
+			gl = append(gl, txt[:n])
+		}
+		join = r == '\u200d'
+
+		txt = txt[n:]
+	}
+	return gl
+}
+
+// parseEllipsis parses the position of an ellipsis, see `truncate`.
+func parseEllipsis(s string) (rune, error) {
+	switch s {
+	case "start":
+		return 's', nil
+	case "middle":
+		return 'm', nil
+	case "end", "":
+		return 'e', nil
 	}
-	return txt
+	return 0, fmt.Errorf("parse %q: Not a valid ellipsis", s)
 }
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/xgbutil/ewmh"
+	"golang.org/x/image/font/basicfont"
+)
+
+func TestTruncate(t *testing.T) {
+	// Every glyph of this face is 7 pixels wide.
+	f := basicfont.Face7x13
+
+	for _, tc := range []struct {
+		txt  string
+		w    int
+		mode rune
+		want string
+	}{
+		{"hello world", 77, 'e', "hello world"},
+		{"hello world", 56, 'e', "hello..."},
+		{"hello world", 56, 's', "...world"},
+		{"hello world", 56, 'm', "he...rld"},
+		{"hello world", 20, 'e', "..."},
+		{"", 0, 'e', ""},
+	} {
+		if s := truncate(f, tc.txt, tc.w, tc.mode); s != tc.want {
+			t.Errorf("truncate(%q, %d, %c) = %q, want %q", tc.txt, tc.w,
+				tc.mode, s, tc.want)
+		}
+	}
+}
+
+func TestGraphemes(t *testing.T) {
+	for _, tc := range []struct {
+		txt  string
+		want []string
+	}{
+		{"", nil},
+		{"abc", []string{"a", "b", "c"}},
+		{"e\u0301a", []string{"e\u0301", "a"}},
+		{"\u2764\ufe0f!", []string{"\u2764\ufe0f", "!"}},
+		{"\U0001f469\u200d\U0001f4bbx", []string{
+			"\U0001f469\u200d\U0001f4bb", "x"}},
+		{"\u0301a", []string{"\u0301", "a"}},
+	} {
+		if gl := graphemes(tc.txt); !reflect.DeepEqual(gl, tc.want) {
+			t.Errorf("graphemes(%q) = %q, want %q", tc.txt, gl, tc.want)
+		}
+	}
+}
+
+func TestBestIcon(t *testing.T) {
+	// Function that creates an icon with valid data.
+	icon := func(w, h uint) ewmh.WmIcon {
+		return ewmh.WmIcon{Width: w, Height: h, Data: make([]uint, w*h)}
+	}
+	il := []ewmh.WmIcon{
+		icon(16, 16),
+		icon(24, 24),
+		icon(32, 32),
+		icon(48, 20),
+		{Width: 22, Height: 22},
+	}
+
+	for _, tc := range []struct {
+		size int
+		want int
+	}{
+		{16, 0},
+		{10, 0},
+		{22, 1},
+		{20, 1},
+		{28, 2},
+		{38, 2},
+		{40, 3},
+		{100, 3},
+	} {
+		if ic := bestIcon(il, tc.size); ic != &il[tc.want] {
+			t.Errorf("bestIcon(%d) = %dx%d, want %dx%d", tc.size, ic.Width,
+				ic.Height, il[tc.want].Width, il[tc.want].Height)
+		}
+	}
+
+	if ic := bestIcon(il[4:], 22); ic != nil {
+		t.Errorf("bestIcon(22) = %dx%d, want no icon", ic.Width, ic.Height)
+	}
+}
+
+func TestScaleIcon(t *testing.T) {
+	il := []ewmh.WmIcon{{Width: 4, Height: 2, Data: make([]uint, 8)}}
+
+	if img := scaleIcon(il, 16); img.Bounds() != image.Rect(0, 0, 16, 8) {
+		t.Errorf("scaleIcon(16) has bounds %v, want 16x8", img.Bounds())
+	}
+	if img := scaleIcon(nil, 16); img != nil {
+		t.Errorf("scaleIcon(16) without icons = %v, want nil", img)
+	}
+}
@@ -1,15 +1,22 @@
 package main
 
 import (
+	"context"
+	"image"
 	"io/ioutil"
 	"log"
+	"os"
+	"sync"
 
 	"github.com/AndreKR/multiface"
 	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/randr"
 	"github.com/BurntSushi/xgb/xproto"
 	"github.com/BurntSushi/xgbutil"
 	"github.com/BurntSushi/xgbutil/ewmh"
+	"github.com/BurntSushi/xgbutil/icccm"
 	"github.com/BurntSushi/xgbutil/xevent"
+	"github.com/BurntSushi/xgbutil/xprop"
 	"github.com/BurntSushi/xgbutil/xwindow"
 	"github.com/zachomedia/go-bdf"
 )
@@ -25,6 +32,15 @@ func initX() error {
 		return err
 	}
 
+	// Initialize the RandR extension, used to query the monitors.
+	if err := randr.Init(X.Conn()); err != nil {
+		return err
+	}
+
+	// Query the XKB extension, used by the keyboard block. Not every X server
+	// has it, so the error is only reported once the extension is used.
+	xkbQueryErr = queryXKB()
+
 	// Run the main X event loop, this is used to catch events.
 	go xevent.Main(X)
 
@@ -33,6 +49,329 @@ func initX() error {
 	return xwindow.New(X, X.RootWin()).Listen(xproto.EventMaskPropertyChange)
 }
 
+// monitor is a struct with information about a connected monitor.
+type monitor struct {
+	// The name of the RandR output, for example `HDMI-1`.
+	name string
+
+	// If the monitor is the primary monitor or not.
+	primary bool
+
+	// The position and size of the monitor.
+	rect image.Rectangle
+}
+
+// monitors returns the connected and enabled monitors.
+func monitors() ([]monitor, error) {
+	res, err := randr.GetScreenResourcesCurrent(X.Conn(), X.RootWin()).Reply()
+	if err != nil {
+		return nil, err
+	}
+	pri, err := randr.GetOutputPrimary(X.Conn(), X.RootWin()).Reply()
+	if err != nil {
+		return nil, err
+	}
+
+	var ml []monitor
+	for _, o := range res.Outputs {
+		oi, err := randr.GetOutputInfo(X.Conn(), o, res.ConfigTimestamp).Reply()
+		if err != nil {
+			return nil, err
+		}
+		if oi.Connection != randr.ConnectionConnected || oi.Crtc == 0 {
+			continue
+		}
+
+		ci, err := randr.GetCrtcInfo(X.Conn(), oi.Crtc, res.ConfigTimestamp).
+			Reply()
+		if err != nil {
+			return nil, err
+		}
+
+		ml = append(ml, monitor{
+			name:    string(oi.Name),
+			primary: o == pri.Output,
+			rect: image.Rect(int(ci.X), int(ci.Y), int(ci.X)+int(ci.Width),
+				int(ci.Y)+int(ci.Height)),
+		})
+	}
+
+	return ml, nil
+}
+
+// match checks if the monitor matches one of the given monitor names. The
+// name `primary` matches the primary monitor, and `*` matches all monitors.
+func (m monitor) match(nl []string) bool {
+	if len(nl) == 0 {
+		return true
+	}
+
+	for _, n := range nl {
+		if n == "*" || n == m.name || (n == "primary" && m.primary) {
+			return true
+		}
+	}
+	return false
+}
+
+// barMonitors returns the monitors that should get a bar. If no monitors
+// are configured, this returns a single unnamed monitor that spans the whole
+// screen.
+func barMonitors(c BarConfig) ([]monitor, error) {
+	ml, err := monitors()
+	if err != nil {
+		return nil, err
+	}
+
+	// The screen size in the connection setup doesn't change when monitors
+	// are added or removed, so use the bounds of all monitors instead.
+	if len(c.Monitors) == 0 {
+		var r image.Rectangle
+		for _, m := range ml {
+			r = r.Union(m.rect)
+		}
+		if r.Empty() {
+			s := X.Screen()
+			r = image.Rect(0, 0, int(s.WidthInPixels), int(s.HeightInPixels))
+		}
+		return []monitor{{primary: true, rect: r}}, nil
+	}
+
+	var bl []monitor
+	for _, m := range ml {
+		if m.match(c.Monitors) {
+			bl = append(bl, m)
+		}
+	}
+	return bl, nil
+}
+
+// barRect calculates the position and size of the bar on the given monitor.
+// The position is relative to the monitor, and a width of zero spans the
+// whole width of the monitor.
+func barRect(c BarConfig, m monitor) image.Rectangle {
+	r := m.rect
+
+	w := c.W
+	if w == 0 {
+		w = r.Dx() - c.X
+	}
+	y := r.Min.Y + c.Y
+	if c.Bottom {
+		y = r.Max.Y - c.H - c.Y
+	}
+
+	return image.Rect(r.Min.X+c.X, y, r.Min.X+c.X+w, y+c.H)
+}
+
+// watchMonitors executes `f` every time the monitor configuration changes,
+// for example when a monitor is connected or disconnected. Output property
+// changes are dispatched to the functions registered using
+// `watchOutputProperties` instead.
+func watchMonitors(f func()) {
+	randr.SelectInput(X.Conn(), X.RootWin(), randr.NotifyMaskScreenChange|
+		randr.NotifyMaskCrtcChange|randr.NotifyMaskOutputChange|randr.
+		NotifyMaskOutputProperty)
+
+	xevent.HookFun(func(_ *xgbutil.XUtil, ev interface{}) bool {
+		switch ev := ev.(type) {
+		case randr.ScreenChangeNotifyEvent:
+			f()
+		case randr.NotifyEvent:
+			if ev.SubCode != randr.NotifyOutputProperty {
+				f()
+				break
+			}
+
+			outputWatchers.Lock()
+			for w := range outputWatchers.m {
+				go (*w)()
+			}
+			outputWatchers.Unlock()
+		}
+		return true
+	}).Connect(X)
+}
+
+// outputWatchers stores the functions that are executed when an output
+// property changes. Hooks can't be removed from the X event loop, so a
+// single hook dispatches to these functions instead.
+var outputWatchers = struct {
+	sync.Mutex
+	m map[*func()]bool
+}{m: make(map[*func()]bool)}
+
+// watchOutputProperties executes `f` every time a property of an output
+// changes, until `ctx` is done.
+func watchOutputProperties(ctx context.Context, f func()) {
+	outputWatchers.Lock()
+	outputWatchers.m[&f] = true
+	outputWatchers.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		outputWatchers.Lock()
+		delete(outputWatchers.m, &f)
+		outputWatchers.Unlock()
+	}()
+}
+
+// watcher is a struct with information about a function that should be
+// executed on property change events.
+type watcher struct {
+	// The properties to watch.
+	atoms map[xproto.Atom]bool
+
+	// The function to execute.
+	f func()
+}
+
+// watchers stores the watchers of each window. The X event loop only knows
+// how to detach all callbacks of a window at once, so we connect a single
+// callback per window that dispatches to these watchers instead.
+var watchers = struct {
+	sync.Mutex
+	m map[xproto.Window]map[*watcher]bool
+}{m: make(map[xproto.Window]map[*watcher]bool)}
+
+// watch executes `f` every time one of the given properties of window `win`
+// changes, until `ctx` is done.
+func watch(ctx context.Context, win xproto.Window, f func(),
+	names ...string) error {
+	w := &watcher{atoms: make(map[xproto.Atom]bool), f: f}
+	for _, n := range names {
+		atom, err := xprop.Atm(X, n)
+		if err != nil {
+			return err
+		}
+		w.atoms[atom] = true
+	}
+
+	watchers.Lock()
+	defer watchers.Unlock()
+
+	// Connect the dispatch callback if this is the first watcher of this
+	// window. We're already listening to the root window for property change
+	// events.
+	if _, ok := watchers.m[win]; !ok {
+		watchers.m[win] = make(map[*watcher]bool)
+
+		if win != X.RootWin() {
+			if err := xwindow.New(X, win).Listen(xproto.
+				EventMaskPropertyChange); err != nil {
+				delete(watchers.m, win)
+				return err
+			}
+		}
+
+		xevent.PropertyNotifyFun(func(_ *xgbutil.XUtil, ev xevent.
+			PropertyNotifyEvent) {
+			watchers.Lock()
+			var fl []func()
+			for w := range watchers.m[ev.Window] {
+				if w.atoms[ev.Atom] {
+					fl = append(fl, w.f)
+				}
+			}
+			watchers.Unlock()
+
+			for _, f := range fl {
+				f()
+			}
+		}).Connect(X, win)
+	}
+	watchers.m[win][w] = true
+
+	// Remove the watcher once the context is done.
+	go func() {
+		<-ctx.Done()
+
+		watchers.Lock()
+		defer watchers.Unlock()
+
+		delete(watchers.m[win], w)
+		if len(watchers.m[win]) == 0 && win != X.RootWin() {
+			delete(watchers.m, win)
+			xevent.Detach(X, win)
+		}
+	}()
+
+	return nil
+}
+
+// client is a struct with information about a window.
+type client struct {
+	// The ID of the window, this is zero if there is no window.
+	id xproto.Window
+
+	// The title of the window.
+	name string
+
+	// The class and instance name from `WM_CLASS`.
+	class, instance string
+}
+
+// getClient reads the information about the window.
+func getClient(id xproto.Window) client {
+	c := client{id: id, name: windowName(id)}
+	if wc, err := icccm.WmClassGet(X, id); err == nil {
+		c.class = wc.Class
+		c.instance = wc.Instance
+	}
+	return c
+}
+
+// watchActive executes `f` with the active window every time the active
+// window changes, and every time its title or one of the given properties
+// changes, until `ctx` is done. The title is read from both `_NET_WM_NAME`
+// and `WM_NAME`.
+func watchActive(ctx context.Context, f func(client), names ...string) error {
+	names = append([]string{"_NET_WM_NAME", "WM_NAME", "WM_CLASS"}, names...)
+
+	// Function that stops listening to the previous window.
+	var mu sync.Mutex
+	cancel := func() {}
+
+	// Get window ID function.
+	t := func() {
+		// Get active window.
+		id, err := ewmh.ActiveWindowGet(X)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		// Stop listening to the previous window.
+		mu.Lock()
+		cancel()
+		var wctx context.Context
+		wctx, cancel = context.WithCancel(ctx)
+		mu.Unlock()
+
+		// Listen to this window for changes, the window might be gone
+		// already so errors are ignored.
+		if id != 0 {
+			watch(wctx, id, func() {
+				f(getClient(id))
+			}, names...)
+			f(getClient(id))
+			return
+		}
+		f(client{})
+	}
+
+	// Listen for window change event, execute `t()` accordingly.
+	if err := watch(ctx, X.RootWin(), t, "_NET_ACTIVE_WINDOW"); err != nil {
+		return err
+	}
+
+	// Execute `t()` one time initially.
+	t()
+
+	return nil
+}
+
 func initEWMH(w xproto.Window) error {
 	// TODO: `WmStateSet` and `WmDesktopSet` are basically here to keep OpenBox
 	// happy, can I somehow remove them and just use `_NET_WM_WINDOW_TYPE_DOCK`
@@ -51,27 +390,124 @@ func initEWMH(w xproto.Window) error {
 	return ewmh.WmNameSet(X, w, "melonbar")
 }
 
-func initFace() error {
-	face = new(multiface.Face)
+// windowName returns the title of the window, or `?` if it doesn't have one.
+func windowName(id xproto.Window) string {
+	txt, err := ewmh.WmNameGet(X, id)
+	if err != nil || len(txt) == 0 {
+		txt, err = icccm.WmNameGet(X, id)
+		if err != nil || len(txt) == 0 {
+			txt = "?"
+		}
+	}
+	return txt
+}
+
+// desktopWindows returns the windows from `_NET_CLIENT_LIST` that are on
+// the current desktop, sticky windows are included.
+func desktopWindows() ([]xproto.Window, error) {
+	cur, err := ewmh.CurrentDesktopGet(X)
+	if err != nil {
+		return nil, err
+	}
+	cl, err := ewmh.ClientListGet(X)
+	if err != nil {
+		return nil, err
+	}
+
+	var wl []xproto.Window
+	for _, id := range cl {
+		d, err := ewmh.WmDesktopGet(X, id)
+		if err != nil {
+			continue
+		}
+		if d == cur || d == 0xFFFFFFFF {
+			wl = append(wl, id)
+		}
+	}
+	return wl, nil
+}
 
-	fpl := []string{
-		"runtime/fonts/cure.punpun.bdf",
-		"runtime/fonts/kochi.small.bdf",
-		"runtime/fonts/baekmuk.small.bdf",
+// hidden checks if the window is minimized.
+func hidden(id xproto.Window) bool {
+	sl, err := ewmh.WmStateGet(X, id)
+	if err != nil {
+		return false
 	}
+	for _, s := range sl {
+		if s == "_NET_WM_STATE_HIDDEN" {
+			return true
+		}
+	}
+	return false
+}
 
-	for _, fp := range fpl {
-		fb, err := runtime.ReadFile(fp)
+// urgent checks if the window demands attention, using either the EWMH
+// `_NET_WM_STATE_DEMANDS_ATTENTION` state or the ICCCM urgency hint.
+func urgent(id xproto.Window) bool {
+	if sl, err := ewmh.WmStateGet(X, id); err == nil {
+		for _, s := range sl {
+			if s == "_NET_WM_STATE_DEMANDS_ATTENTION" {
+				return true
+			}
+		}
+	}
+	if h, err := icccm.WmHintsGet(X, id); err == nil {
+		return h.Flags&icccm.HintUrgency != 0
+	}
+	return false
+}
+
+// setStrut reserves space for the bar window at the top or bottom of the
+// screen, so that maximized windows don't cover the bar. The strut is
+// relative to the edge of the root window, and limited to the horizontal
+// span of the bar. A hidden bar reserves no space at all.
+func setStrut(w xproto.Window, r image.Rectangle, bottom, hidden bool) error {
+	var s ewmh.WmStrutPartial
+	switch {
+	case hidden:
+	case bottom:
+		root, err := xwindow.RawGeometry(X, xproto.Drawable(X.RootWin()))
 		if err != nil {
 			return err
 		}
+		s.Bottom = uint(root.Y() + root.Height() - r.Min.Y)
+		s.BottomStartX = uint(r.Min.X)
+		s.BottomEndX = uint(r.Max.X - 1)
+	default:
+		s.Top = uint(r.Max.Y)
+		s.TopStartX = uint(r.Min.X)
+		s.TopEndX = uint(r.Max.X - 1)
+	}
+
+	// Set `_NET_WM_STRUT` as well for window managers that don't support
+	// partial struts.
+	if err := ewmh.WmStrutSet(X, w, &ewmh.WmStrut{Top: s.Top,
+		Bottom: s.Bottom}); err != nil {
+		return err
+	}
+	return ewmh.WmStrutPartialSet(X, w, &s)
+}
+
+// initFace loads the BDF fonts into a single font face.
+func initFace(fpl []string) (*multiface.Face, error) {
+	f := new(multiface.Face)
+
+	for _, fp := range fpl {
+		// Read the font from disk, fall back to the embedded fonts.
+		fb, err := os.ReadFile(fp)
+		if os.IsNotExist(err) {
+			fb, err = runtime.ReadFile(fp)
+		}
+		if err != nil {
+			return nil, err
+		}
 		ff, err := bdf.Parse(fb)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
-		face.AddFace(ff.NewFace())
+		f.AddFace(ff.NewFace())
 	}
 
-	return nil
+	return f, nil
 }
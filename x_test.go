@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"testing"
+)
+
+func TestBarRect(t *testing.T) {
+	// A monitor to the right of a 1920x1080 monitor.
+	m := monitor{name: "DP-1", rect: image.Rect(1920, 0, 3200, 1024)}
+
+	for _, tc := range []struct {
+		c    BarConfig
+		m    monitor
+		want image.Rectangle
+	}{
+		{BarConfig{H: 29}, monitor{rect: image.Rect(0, 0, 1920, 1080)},
+			image.Rect(0, 0, 1920, 29)},
+		{BarConfig{H: 29}, m, image.Rect(1920, 0, 3200, 29)},
+		{BarConfig{X: 10, Y: 5, H: 20}, m, image.Rect(1930, 5, 3200, 25)},
+		{BarConfig{X: 10, Y: 5, W: 300, H: 20}, m, image.Rect(1930, 5, 2230,
+			25)},
+		{BarConfig{H: 29, Bottom: true}, m, image.Rect(1920, 995, 3200,
+			1024)},
+		{BarConfig{X: 10, Y: 5, W: 300, H: 20, Bottom: true}, m, image.Rect(
+			1930, 999, 2230, 1019)},
+		{BarConfig{X: -10, Y: -5, W: 300, H: 20}, m, image.Rect(1910, -5,
+			2210, 15)},
+		{BarConfig{H: 20}, monitor{rect: image.Rect(0, 1080, 1920, 2160)},
+			image.Rect(0, 1080, 1920, 1100)},
+	} {
+		if r := barRect(tc.c, tc.m); r != tc.want {
+			t.Errorf("barRect(%+v, %v) = %v, want %v", tc.c, tc.m.rect, r,
+				tc.want)
+		}
+	}
+}
+
+func TestMonitorMatch(t *testing.T) {
+	pri := monitor{name: "eDP-1", primary: true}
+	sec := monitor{name: "HDMI-1"}
+
+	for _, tc := range []struct {
+		m    monitor
+		nl   []string
+		want bool
+	}{
+		{pri, nil, true},
+		{sec, nil, true},
+		{pri, []string{"*"}, true},
+		{sec, []string{"*"}, true},
+		{pri, []string{"primary"}, true},
+		{sec, []string{"primary"}, false},
+		{pri, []string{"eDP-1"}, true},
+		{sec, []string{"eDP-1"}, false},
+		{sec, []string{"eDP-1", "HDMI-1"}, true},
+		{sec, []string{"primary", "HDMI-1"}, true},
+		{sec, []string{"hdmi-1"}, false},
+		{sec, []string{"HDMI"}, false},
+		{sec, []string{""}, false},
+	} {
+		if ok := tc.m.match(tc.nl); ok != tc.want {
+			t.Errorf("%q.match(%q) = %t, want %t", tc.m.name, tc.nl, ok,
+				tc.want)
+		}
+	}
+}
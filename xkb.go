@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgbutil"
+	"github.com/BurntSushi/xgbutil/xevent"
+	"github.com/BurntSushi/xgbutil/xprop"
+)
+
+// xgb doesn't implement the XKB extension, so the few requests and the event
+// we need are implemented here. See the XKB protocol specification for the
+// encoding.
+
+// The minor opcodes of the XKB requests.
+const (
+	xkbUseExtension   = 0
+	xkbSelectEvents   = 1
+	xkbGetState       = 4
+	xkbLatchLockState = 5
+)
+
+// The XKB event type of state notify events, the mask that selects them, and
+// the parts of the state we want to be notified about.
+const (
+	xkbStateNotify     = 2
+	xkbStateNotifyMask = 1 << 2
+
+	xkbModifierLock = 1 << 3
+	xkbGroupState   = 1 << 4
+	xkbGroupLock    = 1 << 7
+)
+
+// xkbUseCoreKbd is the device specifier of the core keyboard.
+const xkbUseCoreKbd = 0x0100
+
+// The modifier masks of Caps Lock, and of Num Lock which is usually bound to
+// Mod2.
+const (
+	xkbCapsLock = 1 << 1
+	xkbNumLock  = 1 << 4
+)
+
+// xkbState is a struct with the keyboard state.
+type xkbState struct {
+	// The effective and locked group.
+	group, lockedGroup byte
+
+	// The locked modifiers.
+	lockedMods byte
+}
+
+// xkbEvent is an XKB event. All XKB events share one event code, the type of
+// the event is the second byte.
+type xkbEvent struct {
+	buf []byte
+}
+
+func (ev xkbEvent) Bytes() []byte {
+	return ev.buf
+}
+
+func (ev xkbEvent) String() string {
+	return fmt.Sprintf("XkbEvent {xkbType: %d}", ev.buf[1])
+}
+
+// state returns the keyboard state of a state notify event.
+func (ev xkbEvent) state() (xkbState, bool) {
+	if ev.buf[1] != xkbStateNotify {
+		return xkbState{}, false
+	}
+	return xkbState{
+		group:       ev.buf[13],
+		lockedGroup: ev.buf[18],
+		lockedMods:  ev.buf[12],
+	}, true
+}
+
+var (
+	// The major opcode of the XKB extension, and the error of querying it.
+	// These are set by `queryXKB`.
+	xkbOpcode   byte
+	xkbQueryErr error
+
+	// The error of initializing the extension, see `initXKB`.
+	xkbInitErr  error
+	xkbInitOnce sync.Once
+
+	// The functions that are executed on state notify events. Hooks can't
+	// be removed from the X event loop, so a single hook dispatches to these
+	// functions instead.
+	xkbWatchers = struct {
+		sync.Mutex
+		m map[*func(xkbState)]bool
+	}{m: make(map[*func(xkbState)]bool)}
+)
+
+// queryXKB queries the XKB extension, and registers the decoder of its events.
+// This should be called before the X event loop runs, as xgb reads the
+// decoders without locking.
+func queryXKB() error {
+	ext, err := xproto.QueryExtension(X.Conn(), 9, "XKEYBOARD").Reply()
+	if err != nil {
+		return err
+	}
+	if !ext.Present {
+		return fmt.Errorf("xkb: Extension not present")
+	}
+	xkbOpcode = ext.MajorOpcode
+
+	// All XKB events share the first event code of the extension.
+	xgb.NewEventFuncs[int(ext.FirstEvent)] = func(buf []byte) xgb.Event {
+		return xkbEvent{buf: buf}
+	}
+
+	return nil
+}
+
+// initXKB initializes the XKB extension, and selects state notify events of
+// the core keyboard. It is only executed once.
+func initXKB() error {
+	xkbInitOnce.Do(func() {
+		if xkbQueryErr != nil {
+			xkbInitErr = xkbQueryErr
+			return
+		}
+
+		// The extension has to be enabled before it can be used.
+		buf := xkbRequest(xkbUseExtension, 8)
+		xgb.Put16(buf[4:], 1)
+		xgb.Put16(buf[6:], 0)
+		r, err := xkbReply(buf)
+		if err != nil {
+			xkbInitErr = err
+			return
+		}
+		if r[1] == 0 {
+			xkbInitErr = fmt.Errorf("xkb: Version 1.0 not supported")
+			return
+		}
+
+		// Select state notify events about the group and locked modifiers.
+		buf = xkbRequest(xkbSelectEvents, 20)
+		xgb.Put16(buf[4:], xkbUseCoreKbd)
+		xgb.Put16(buf[6:], xkbStateNotifyMask)
+		xgb.Put16(buf[16:], xkbGroupState|xkbGroupLock|xkbModifierLock)
+		xgb.Put16(buf[18:], xkbGroupState|xkbGroupLock|xkbModifierLock)
+		if err := xkbCheck(buf); err != nil {
+			xkbInitErr = err
+			return
+		}
+
+		xevent.HookFun(func(_ *xgbutil.XUtil, ev interface{}) bool {
+			xev, ok := ev.(xkbEvent)
+			if !ok {
+				return true
+			}
+			// Execute the watchers in order, so that they see the states in
+			// the order they happened.
+			if st, ok := xev.state(); ok {
+				xkbWatchers.Lock()
+				for w := range xkbWatchers.m {
+					(*w)(st)
+				}
+				xkbWatchers.Unlock()
+			}
+			return false
+		}).Connect(X)
+	})
+
+	return xkbInitErr
+}
+
+// xkbRequest returns a buffer for an XKB request of `n` bytes, with the
+// header filled in.
+func xkbRequest(minor byte, n int) []byte {
+	buf := make([]byte, n)
+	buf[0] = xkbOpcode
+	buf[1] = minor
+	xgb.Put16(buf[2:], uint16(n/4))
+	return buf
+}
+
+// xkbReply sends the request and waits for its reply.
+func xkbReply(buf []byte) ([]byte, error) {
+	c := X.Conn().NewCookie(true, true)
+	X.Conn().NewRequest(buf, c)
+	return c.Reply()
+}
+
+// xkbCheck sends the request and waits for it to be processed.
+func xkbCheck(buf []byte) error {
+	c := X.Conn().NewCookie(true, false)
+	X.Conn().NewRequest(buf, c)
+	return c.Check()
+}
+
+// getXKBState returns the keyboard state of the core keyboard.
+func getXKBState() (xkbState, error) {
+	if err := initXKB(); err != nil {
+		return xkbState{}, err
+	}
+
+	buf := xkbRequest(xkbGetState, 8)
+	xgb.Put16(buf[4:], xkbUseCoreKbd)
+	r, err := xkbReply(buf)
+	if err != nil {
+		return xkbState{}, err
+	}
+	if len(r) < 14 {
+		return xkbState{}, fmt.Errorf("xkb: Not a valid state")
+	}
+
+	return xkbState{group: r[12], lockedGroup: r[13], lockedMods: r[11]}, nil
+}
+
+// lockXKBGroup locks the group of the core keyboard.
+func lockXKBGroup(group int) error {
+	if err := initXKB(); err != nil {
+		return err
+	}
+
+	buf := xkbRequest(xkbLatchLockState, 16)
+	xgb.Put16(buf[4:], xkbUseCoreKbd)
+	buf[8] = 1
+	buf[9] = byte(group)
+	return xkbCheck(buf)
+}
+
+// watchXKB executes `f` every time the group or locked modifiers of the core
+// keyboard change, until `ctx` is done. The function is executed from the X
+// event loop, so it shouldn't block.
+func watchXKB(ctx context.Context, f func(xkbState)) error {
+	if err := initXKB(); err != nil {
+		return err
+	}
+
+	xkbWatchers.Lock()
+	xkbWatchers.m[&f] = true
+	xkbWatchers.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		xkbWatchers.Lock()
+		delete(xkbWatchers.m, &f)
+		xkbWatchers.Unlock()
+	}()
+
+	return nil
+}
+
+// xkbLayouts returns the layout of every group, read from the
+// `_XKB_RULES_NAMES` property of the root window. This property contains the
+// rules, model, layouts, variants and options, separated by null bytes.
+func xkbLayouts() []string {
+	r, err := xprop.GetProperty(X, X.RootWin(), "_XKB_RULES_NAMES")
+	if err != nil {
+		return nil
+	}
+	fl := strings.Split(string(r.Value), "\x00")
+	if len(fl) < 3 || fl[2] == "" {
+		return nil
+	}
+	return strings.Split(fl[2], ",")
+}
+
+// cycleXKBGroup locks the group `d` positions away from the current group,
+// wrapping around at both ends.
+func cycleXKBGroup(d int) error {
+	st, err := getXKBState()
+	if err != nil {
+		return err
+	}
+	n := len(xkbLayouts())
+	if n == 0 {
+		return nil
+	}
+
+	return lockXKBGroup(((int(st.lockedGroup)+d)%n + n) % n)
+}